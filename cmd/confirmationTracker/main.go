@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Snipa22/core-go-lib/helpers"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/events"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/nodeGRPC"
+	"github.com/sirupsen/logrus"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/* confirmationTracker follows every payout in `transaction_details` until it reaches a final state.
+
+Every poll it loads the rows that aren't `rechecked` yet, asks the wallet for each one, and stores the status, mined
+	height and cancellation it reports:
+	1. Newly seen in a block (or moved to another block by a reorg), publish a mined event.
+	2. Buried under --confirmations blocks from the base node tip, publish a confirmed event and set `rechecked`.
+	3. Cancelled or rejected, publish a dropped event and set `rechecked`.
+Rows with `rechecked` set are never polled again.  Events go to the redis channel set by --event-channel, see the
+	events package.  SIGTERM or SIGINT cancels the wallet and store calls in flight and exits once the current poll
+	stops, anything not stored yet is picked up by the next start.
+*/
+
+type tracker struct {
+	milieu        *core.Milieu
+	store         sql.Store
+	walletClient  wallet.Client
+	confirmations uint64
+	channel       string
+}
+
+// check polls the wallet for a single row and stores what it finds
+func (t *tracker) check(ctx context.Context, detail sql.TransactionDetail, tipHeight uint64) error {
+	txInfo, err := t.walletClient.GetTransactionInfoByID(ctx, detail.ID)
+	if err != nil {
+		return err
+	}
+	if txInfo == nil {
+		// Nothing came back, this is a wallet problem rather than an answer, try again next poll
+		t.milieu.Debug(fmt.Sprintf("No wallet data for %v, skipping", detail.ID))
+		return nil
+	}
+
+	event := events.Event{
+		TxID:          detail.ID,
+		Status:        uint64(txInfo.Status.Number()),
+		Amount:        detail.Amount,
+		MinedAtHeight: txInfo.MinedInBlockHeight,
+		DestAddress:   detail.DestAddress,
+	}
+	rechecked := false
+	eventTypes := make([]string, 0, 2)
+	switch {
+	case wallet.IsDropped(txInfo):
+		rechecked = true
+		eventTypes = append(eventTypes, events.Dropped)
+	case txInfo.MinedInBlockHeight > 0:
+		if txInfo.MinedInBlockHeight != detail.MinedAtHeight {
+			eventTypes = append(eventTypes, events.Mined)
+		}
+		if tipHeight >= txInfo.MinedInBlockHeight {
+			event.Confirmations = tipHeight - txInfo.MinedInBlockHeight + 1
+		}
+		if event.Confirmations >= t.confirmations {
+			rechecked = true
+			eventTypes = append(eventTypes, events.Confirmed)
+		}
+	}
+
+	if err = t.store.UpdateTransactionDetailStatus(ctx, detail.ID, event.Status, txInfo.IsCancelled, txInfo.MinedInBlockHeight, rechecked); err != nil {
+		return err
+	}
+	// Only publish once the row is stored, so a failed update means the event is sent again on the next poll rather
+	// than never
+	for _, eventType := range eventTypes {
+		event.Type = eventType
+		events.Publish(t.milieu, t.channel, event)
+	}
+	return nil
+}
+
+// poll checks every unchecked row, cancelling ctx stops it before the next one
+func (t *tracker) poll(ctx context.Context) {
+	details, err := t.store.GetUncheckedTransactionDetails(ctx)
+	if err != nil {
+		t.milieu.CaptureException(err)
+		t.milieu.Info(err.Error())
+		return
+	}
+	if len(details) == 0 {
+		t.milieu.Debug("No unconfirmed transactions")
+		return
+	}
+	tipInfo, err := nodeGRPC.GetTipInfo()
+	if err != nil {
+		// Without the tip we can still track mined and dropped, confirmations just won't move
+		t.milieu.CaptureException(err)
+		t.milieu.Info(err.Error())
+	}
+	tipHeight := tipInfo.GetMetadata().GetBestBlockHeight()
+	t.milieu.Info(fmt.Sprintf("Checking %v unconfirmed transactions at tip %v", len(details), tipHeight))
+	for _, detail := range details {
+		if ctx.Err() != nil {
+			t.milieu.Info("Shutting down, leaving the remaining transactions for the next poll")
+			return
+		}
+		// A call cut short by the shutdown isn't worth reporting, the loop stops on the next pass
+		if err = t.check(ctx, detail, tipHeight); err != nil && ctx.Err() == nil {
+			t.milieu.CaptureException(err)
+			t.milieu.Info(err.Error())
+		}
+	}
+}
+
+func main() {
+	psqlURL := helpers.GetEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
+	redisURI := helpers.GetEnv("REDIS_SERVER", "redis://redis:6379/0")
+	sentryURI := helpers.GetEnv("SENTRY_SERVER", "")
+
+	// Build Milieu
+	milieu, err := core.NewMilieu(&psqlURL, &redisURI, &sentryURI)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+
+	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
+	baseNodeGRPCAddressPtr := flag.String("base-node-grpc-address", "127.0.0.1:18142", "Tari base node GRPC address, used for the chain tip")
+	pollIntervalPtr := flag.Duration("poll-interval", time.Minute, "How often to poll the wallet for unconfirmed transactions")
+	confirmationsPtr := flag.Uint64("confirmations", 3, "Blocks a payout needs, including its own, before it is confirmed and no longer polled")
+	eventChannelPtr := flag.String("event-channel", events.DefaultChannel, "Redis pub/sub channel to publish payout events on")
+	runOncePtr := flag.Bool("run-once", false, "Poll once and exit")
+	debugEnabledPtr := flag.Bool("debug-enabled", false, "Enable debug logging")
+	flag.Parse()
+
+	if *debugEnabledPtr {
+		milieu.SetLogLevel(logrus.DebugLevel)
+	}
+	nodeGRPC.InitNodeGRPC(*baseNodeGRPCAddressPtr)
+
+	t := &tracker{
+		milieu:        milieu,
+		store:         sql.NewPostgresStore(milieu),
+		walletClient:  wallet.NewGRPCClient(*walletGRPCAddressPtr),
+		confirmations: *confirmationsPtr,
+		channel:       *eventChannelPtr,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	t.poll(ctx)
+	if *runOncePtr {
+		return
+	}
+	ticker := time.NewTicker(*pollIntervalPtr)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			milieu.Info("Shutdown signal received, exiting")
+			return
+		case <-ticker.C:
+			t.poll(ctx)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Snipa22/core-go-lib/helpers"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* faucetServer is the public side of the faucet, it takes claims for testnet coins over HTTP:
+
+	POST /claim {"address": "<tari address>"}
+
+A claim is checked to be a well formed address for --tari-network and turned into its canonical base58 form, so the
+	hex and emoji forms of an address are the same claimant.  It is then rate limited per address and per IP using redis
+	keys `faucet_claim_<address>` and `faucet_claim_ip_<ip>` that expire after the cooldown, then in a single PSQL txn the `balances` row for the address
+	is created if needed and credited with the claim amount.  Finally the `bal_bypass_<address>` key is set so the next
+	payoutDaemon run pays it out regardless of the payout minimum.
+
+faucetServer never talks to the wallet, payoutDaemon does all the sending.
+*/
+
+type faucetServer struct {
+	milieu       *core.Milieu
+	store        sql.Store
+	network      address.Network
+	claimAmount  uint64
+	cooldown     time.Duration
+	trustProxy   bool
+	maxBodyBytes int64
+}
+
+type claimRequest struct {
+	Address string `json:"address"`
+}
+
+type claimResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Amount  uint64 `json:"amount,omitempty"`
+}
+
+func (f *faucetServer) clientIP(r *http.Request) string {
+	if f.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (f *faucetServer) respond(w http.ResponseWriter, status int, resp claimResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// creditClaim credits the claim amount to the address, creating the balance if this is its first claim
+func (f *faucetServer) creditClaim(ctx context.Context, claimAddress string) error {
+	txn, err := f.store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	balanceID, err := f.store.GetOrCreateBalance(ctx, txn, claimAddress)
+	if err != nil {
+		return err
+	}
+	if err = f.store.IncreaseBalance(ctx, txn, balanceID, f.claimAmount, sql.LedgerCredit, "faucet claim"); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (f *faucetServer) handleClaim(w http.ResponseWriter, r *http.Request) {
+	var req claimRequest
+	r.Body = http.MaxBytesReader(w, r.Body, f.maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.respond(w, http.StatusBadRequest, claimResponse{Status: "error", Message: "request body must be {\"address\": \"...\"}"})
+		return
+	}
+	parsed, err := address.Validate(req.Address, f.network)
+	if err != nil {
+		f.respond(w, http.StatusBadRequest, claimResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	// Everything below is keyed on the canonical form, see address.Canonical
+	claimAddress := parsed.String()
+
+	// Take both cooldown keys up front, SetNX means two concurrent claims can't both get through.
+	addressKey := fmt.Sprintf("faucet_claim_%v", claimAddress)
+	ipKey := fmt.Sprintf("faucet_claim_ip_%v", f.clientIP(r))
+	redis := f.milieu.GetRedis()
+	ok, err := redis.SetNX(context.Background(), addressKey, 1, f.cooldown).Result()
+	if err != nil {
+		f.milieu.CaptureException(err)
+		f.respond(w, http.StatusInternalServerError, claimResponse{Status: "error", Message: "unable to process claim"})
+		return
+	}
+	if !ok {
+		f.respond(w, http.StatusTooManyRequests, claimResponse{Status: "error", Message: "this address has already claimed recently"})
+		return
+	}
+	ok, err = redis.SetNX(context.Background(), ipKey, 1, f.cooldown).Result()
+	if err != nil || !ok {
+		redis.Del(context.Background(), addressKey)
+		if err != nil {
+			f.milieu.CaptureException(err)
+			f.respond(w, http.StatusInternalServerError, claimResponse{Status: "error", Message: "unable to process claim"})
+			return
+		}
+		f.respond(w, http.StatusTooManyRequests, claimResponse{Status: "error", Message: "too many claims from this IP, try again later"})
+		return
+	}
+
+	if err = f.creditClaim(r.Context(), claimAddress); err != nil {
+		// Nothing was credited, hand the cooldown back so the user can retry.
+		redis.Del(context.Background(), addressKey, ipKey)
+		f.milieu.CaptureException(err)
+		f.milieu.Info(err.Error())
+		f.respond(w, http.StatusInternalServerError, claimResponse{Status: "error", Message: "unable to process claim"})
+		return
+	}
+	redis.Set(context.Background(), fmt.Sprintf("bal_bypass_%v", claimAddress), 1, 0)
+
+	f.milieu.Info(fmt.Sprintf("Credited %v to %v", f.claimAmount, claimAddress))
+	f.respond(w, http.StatusOK, claimResponse{Status: "ok", Amount: f.claimAmount})
+}
+
+func main() {
+	psqlURL := helpers.GetEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
+	redisURI := helpers.GetEnv("REDIS_SERVER", "redis://redis:6379/0")
+	sentryURI := helpers.GetEnv("SENTRY_SERVER", "")
+
+	// Build Milieu
+	milieu, err := core.NewMilieu(&psqlURL, &redisURI, &sentryURI)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+
+	listenPtr := flag.String("listen", ":8080", "Address to listen for claims on")
+	claimAmountPtr := flag.Uint64("claim-amount", 10000000, "Amount in microTari credited per claim")
+	cooldownPtr := flag.Duration("claim-cooldown", 24*time.Hour, "How long an address or IP has to wait between claims")
+	trustProxyPtr := flag.Bool("trust-proxy", false, "Use X-Forwarded-For for the client IP, only enable behind a proxy that sets it")
+	tariNetworkPtr := flag.String("tari-network", "esmeralda", "Tari network claimed addresses must be for")
+	debugEnabledPtr := flag.Bool("debug-enabled", false, "Enable debug logging")
+	flag.Parse()
+
+	if *debugEnabledPtr {
+		milieu.SetLogLevel(logrus.DebugLevel)
+	}
+
+	network, err := address.ParseNetwork(*tariNetworkPtr)
+	if err != nil {
+		milieu.Fatal(err.Error())
+	}
+
+	server := &faucetServer{
+		milieu:       milieu,
+		network:      network,
+		store:        sql.NewPostgresStore(milieu),
+		claimAmount:  *claimAmountPtr,
+		cooldown:     *cooldownPtr,
+		trustProxy:   *trustProxyPtr,
+		maxBodyBytes: 4096,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /claim", server.handleClaim)
+
+	milieu.Info(fmt.Sprintf("Listening for claims on %v", *listenPtr))
+	httpServer := &http.Server{
+		Addr:              *listenPtr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err = httpServer.ListenAndServe(); err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Snipa22/core-go-lib/helpers"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"os"
+	"time"
+)
+
+/* ledgerVerify recomputes every balance from its `balance_ledger` entries and prints each one that doesn't match.
+	A mismatch means the balance was changed without going through IncreaseBalance/DecreaseBalance, by hand or by
+	something outside this repo, and the command exits non-zero while there is any.
+
+With --record-drift a manual adjustment is written for each mismatch so the ledger matches the balance again, the
+	balance itself is never touched.  Each one is written to `audit_log` under a single run ID.
+*/
+
+func main() {
+	psqlURL := helpers.GetEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
+	sentryURI := helpers.GetEnv("SENTRY_SERVER", "")
+
+	// Build Milieu
+	milieu, err := core.NewMilieu(&psqlURL, nil, &sentryURI)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+
+	recordDriftPtr := flag.Bool("record-drift", false, "Write a manual adjustment for each mismatch, otherwise only report")
+	runIDPtr := flag.String("run-id", fmt.Sprintf("ledgerVerify-%v", time.Now().Unix()), "ID the audit log entries of this run are recorded under")
+	actorPtr := flag.String("actor", helpers.GetEnv("USER", "ledgerVerify"), "Who is running the verify, for the audit log")
+	sqlQueryTimeoutPtr := flag.Duration("sql-query-timeout", sql.BulkQueryTimeout, "Longest a single PSQL query can take before it is abandoned, 0 for no limit")
+	flag.Parse()
+	store := sql.NewPostgresStore(milieu)
+	store.QueryTimeout = *sqlQueryTimeoutPtr
+
+	drift, err := store.GetLedgerDrift(context.Background())
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	if len(drift) == 0 {
+		fmt.Printf("Every balance matches its ledger\n")
+		return
+	}
+
+	fmt.Printf("%d balances don't match their ledger:\n", len(drift))
+	fmt.Printf("  %-10v %-20v %-20v %-20v %v\n", "id", "balance", "ledger", "difference", "address")
+	for _, d := range drift {
+		fmt.Printf("  %-10v %-20v %-20v %-20v %v\n", d.BalanceID, d.Balance, d.LedgerTotal, d.Balance-d.LedgerTotal, d.Address)
+	}
+	if !*recordDriftPtr {
+		fmt.Printf("\nRun again with --record-drift once the cause is understood to bring the ledger in line\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nRecording adjustments as run %v\n", *runIDPtr)
+	failed := 0
+	for _, d := range drift {
+		difference := d.Balance - d.LedgerTotal
+		detail := fmt.Sprintf("balance %v, ledger %v, adjusted by %v", d.Balance, d.LedgerTotal, difference)
+		err = store.CreateLedgerAdjustment(context.Background(), d.BalanceID, difference, *runIDPtr)
+		if err != nil {
+			milieu.CaptureException(err)
+			fmt.Printf("  %v: FAILED: %v\n", d.BalanceID, err)
+			detail = fmt.Sprintf("%v, FAILED: %v", detail, err)
+			failed += 1
+		} else {
+			fmt.Printf("  %v: adjusted by %v\n", d.BalanceID, difference)
+		}
+		if auditErr := store.CreateAuditEntry(context.Background(), sql.AuditEntry{
+			RunID:   *runIDPtr,
+			Actor:   *actorPtr,
+			Action:  "ledger_adjustment",
+			Subject: fmt.Sprintf("balance:%v", d.BalanceID),
+			Detail:  detail,
+		}); auditErr != nil {
+			milieu.CaptureException(auditErr)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
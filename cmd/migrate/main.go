@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Snipa22/core-go-lib/helpers"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql/migrations"
+	"os"
+	"strconv"
+)
+
+/* migrate brings the PSQL schema in line with what payoutDaemon and the tools expect
+
+migrate up                  apply every pending migration
+migrate down [steps]        revert the last applied migration, or the last <steps> of them
+migrate status              list every migration and whether it has been applied
+migrate baseline <version>  record migrations up to <version> as applied without running them
+*/
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [steps] | status | baseline <version>")
+	os.Exit(2)
+}
+
+func main() {
+	psqlURL := helpers.GetEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
+	sentryURI := helpers.GetEnv("SENTRY_SERVER", "")
+
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	// Build Milieu
+	milieu, err := core.NewMilieu(&psqlURL, nil, &sentryURI)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		ran, err := migrations.Up(milieu)
+		for _, migration := range ran {
+			fmt.Printf("Applied %04d_%v\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Fatal(err.Error())
+		}
+		if len(ran) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			if steps, err = strconv.Atoi(flag.Arg(1)); err != nil {
+				usage()
+			}
+		}
+		ran, err := migrations.Down(milieu, steps)
+		for _, migration := range ran {
+			fmt.Printf("Reverted %04d_%v\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Fatal(err.Error())
+		}
+	case "status":
+		statuses, unknown, err := migrations.GetStatus(milieu)
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Fatal(err.Error())
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("%04d_%-40v applied %v\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04:05 MST"))
+			} else {
+				fmt.Printf("%04d_%-40v pending\n", status.Version, status.Name)
+			}
+		}
+		for _, version := range unknown {
+			fmt.Printf("%04d is applied but not known to this binary, the database is newer than the code\n", version)
+		}
+	case "baseline":
+		if flag.NArg() < 2 {
+			usage()
+		}
+		version, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			usage()
+		}
+		if err = migrations.Baseline(milieu, version); err != nil {
+			milieu.CaptureException(err)
+			milieu.Fatal(err.Error())
+		}
+		fmt.Printf("Recorded migrations up to %04d as applied\n", version)
+	default:
+		usage()
+	}
+}
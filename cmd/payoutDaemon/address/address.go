@@ -0,0 +1,215 @@
+package address
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* address decodes and validates Tari addresses, so bad ones are caught before they are handed to the wallet.
+
+A Tari address is, as bytes:
+	network (1) | features (1) | [view key (32)] | spend key (32) | [payment ID (up to 256)] | checksum (1)
+Single addresses carry only the spend key (35 bytes), dual addresses carry both keys (67 bytes) and optionally a payment
+	ID.  The checksum is a Damm checksum over everything before it.
+
+Addresses are accepted as base58, hex or emoji id.  The base58 form encodes the network and features bytes as a single
+	character each, followed by the base58 of the rest.  The emoji id maps every byte to an emoji, see emojiAlphabet.
+	All three are the same address, anything keyed or stored on an address has to use String (see Canonical) so a
+	wallet can't pass for three.
+*/
+
+type Network byte
+
+const (
+	MainNet   Network = 0x00
+	StageNet  Network = 0x01
+	NextNet   Network = 0x02
+	LocalNet  Network = 0x10
+	Igor      Network = 0x24
+	Esmeralda Network = 0x26
+)
+
+var networkNames = map[Network]string{
+	MainNet:   "mainnet",
+	StageNet:  "stagenet",
+	NextNet:   "nextnet",
+	LocalNet:  "localnet",
+	Igor:      "igor",
+	Esmeralda: "esmeralda",
+}
+
+func (n Network) String() string {
+	if name, ok := networkNames[n]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(0x%02x)", byte(n))
+}
+
+// ParseNetwork maps a network name, as used by the Tari binaries, to its network byte
+func ParseNetwork(name string) (Network, error) {
+	for network, networkName := range networkNames {
+		if strings.EqualFold(name, networkName) {
+			return network, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tari network %q", name)
+}
+
+const (
+	keySize          = 32
+	singleSize       = 1 + 1 + keySize + 1
+	dualSize         = 1 + 1 + keySize + keySize + 1
+	maxPaymentIDSize = 256
+)
+
+var (
+	ErrEmpty           = errors.New("address is empty")
+	ErrInvalidEmoji    = errors.New("address is not a valid emoji id")
+	ErrInvalidEncoding = errors.New("address is not valid base58 or hex")
+	ErrInvalidLength   = errors.New("address has an invalid length")
+	ErrInvalidChecksum = errors.New("address checksum does not match")
+	ErrUnknownNetwork  = errors.New("address is for an unknown network")
+	ErrWrongNetwork    = errors.New("address is for the wrong network")
+)
+
+type Address struct {
+	Network   Network
+	Features  byte
+	ViewKey   []byte
+	SpendKey  []byte
+	PaymentID []byte
+	raw       []byte
+}
+
+// Bytes returns the address in its binary form, as the wallet reports it in TransactionInfo.DestAddress
+func (a *Address) Bytes() []byte {
+	return append([]byte(nil), a.raw...)
+}
+
+// String is the address in base58, the canonical form it is stored and keyed under whichever form it was given in
+func (a *Address) String() string {
+	// Every known network fits in a base58 character, features that don't can only be written as hex
+	if a.raw[1] >= byte(len(base58Alphabet)) {
+		return hex.EncodeToString(a.raw)
+	}
+	return string([]byte{base58Alphabet[a.raw[0]], base58Alphabet[a.raw[1]]}) + encodeBase58(a.raw[2:])
+}
+
+func (a *Address) IsDual() bool {
+	return a.ViewKey != nil
+}
+
+// dammChecksum is the Damm checksum Tari uses, over GF(2^8) with the reduction mask 0x1B.  Run over data ending in
+// its own checksum it returns 0.
+func dammChecksum(data []byte) byte {
+	const mask = 0x1B
+	var result byte
+	for _, digit := range data {
+		result ^= digit
+		overflow := result&0x80 != 0
+		result <<= 1
+		if overflow {
+			result ^= mask
+		}
+	}
+	return result
+}
+
+// FromBytes validates and splits the binary form of an address
+func FromBytes(raw []byte) (*Address, error) {
+	if len(raw) != singleSize && (len(raw) < dualSize || len(raw) > dualSize+maxPaymentIDSize) {
+		return nil, ErrInvalidLength
+	}
+	if dammChecksum(raw) != 0 {
+		return nil, ErrInvalidChecksum
+	}
+	result := &Address{Network: Network(raw[0]), Features: raw[1], raw: append([]byte(nil), raw...)}
+	if _, ok := networkNames[result.Network]; !ok {
+		return nil, ErrUnknownNetwork
+	}
+	if len(raw) == singleSize {
+		result.SpendKey = result.raw[2 : 2+keySize]
+		return result, nil
+	}
+	result.ViewKey = result.raw[2 : 2+keySize]
+	result.SpendKey = result.raw[2+keySize : 2+2*keySize]
+	if len(raw) > dualSize {
+		result.PaymentID = result.raw[2+2*keySize : len(raw)-1]
+	}
+	return result, nil
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEmoji reports whether the address is written as an emoji id
+func IsEmoji(s string) bool {
+	return isEmoji(strings.TrimSpace(s))
+}
+
+// Parse decodes an address from its base58, hex or emoji id form
+func Parse(s string) (*Address, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrEmpty
+	}
+	if isEmoji(s) {
+		raw, err := decodeEmoji(s)
+		if err != nil {
+			return nil, ErrInvalidEmoji
+		}
+		return FromBytes(raw)
+	}
+	if isHex(s) && len(s)%2 == 0 && len(s) >= 2*singleSize {
+		raw, err := hex.DecodeString(s)
+		if err == nil {
+			if result, err := FromBytes(raw); err == nil {
+				return result, nil
+			}
+		}
+		// Could still be base58 that happens to only use hex characters, fall through
+	}
+	if len(s) < 3 {
+		return nil, ErrInvalidLength
+	}
+	network := base58Index[s[0]]
+	features := base58Index[s[1]]
+	if network < 0 || features < 0 {
+		return nil, ErrInvalidEncoding
+	}
+	rest, err := decodeBase58(s[2:])
+	if err != nil {
+		return nil, ErrInvalidEncoding
+	}
+	return FromBytes(append([]byte{byte(network), byte(features)}, rest...))
+}
+
+// Canonical parses the address in any form and returns its String
+func Canonical(s string) (string, error) {
+	result, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// Validate parses the address and checks it is for the given network
+func Validate(s string, network Network) (*Address, error) {
+	result, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if result.Network != network {
+		return nil, fmt.Errorf("%w: %v, expected %v", ErrWrongNetwork, result.Network, network)
+	}
+	return result, nil
+}
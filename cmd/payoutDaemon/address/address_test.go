@@ -0,0 +1,165 @@
+package address
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testRaw builds the binary form of an address with a valid checksum, one key makes a single address, two a dual
+func testRaw(network Network, paymentID []byte, keys ...byte) []byte {
+	raw := []byte{byte(network), 0x01}
+	for _, key := range keys {
+		raw = append(raw, make([]byte, keySize)...)
+		for i := len(raw) - keySize; i < len(raw); i++ {
+			raw[i] = key
+		}
+	}
+	raw = append(raw, paymentID...)
+	// Damm over the data, then the checksum, comes out at 0 exactly when the checksum is the Damm of the data
+	return append(raw, dammChecksum(raw))
+}
+
+// testBase58 is the base58 form of an address, network and features as a character each then the rest
+func testBase58(raw []byte) string {
+	return string(base58Alphabet[raw[0]]) + string(base58Alphabet[raw[1]]) + encodeBase58(raw[2:])
+}
+
+func testEmoji(raw []byte) string {
+	var out strings.Builder
+	for _, b := range raw {
+		out.WriteRune(emojiAlphabet[b])
+	}
+	return out.String()
+}
+
+func TestParseForms(t *testing.T) {
+	single := testRaw(Esmeralda, nil, 0xaa)
+	dual := testRaw(Esmeralda, []byte("invoice 42"), 0xbb, 0xcc)
+	for _, raw := range [][]byte{single, dual} {
+		forms := map[string]string{
+			"hex":    hex.EncodeToString(raw),
+			"base58": testBase58(raw),
+			"emoji":  testEmoji(raw),
+		}
+		for form, s := range forms {
+			parsed, err := Parse(s)
+			if err != nil {
+				t.Fatalf("%v %x: %v", form, raw, err)
+			}
+			if string(parsed.Bytes()) != string(raw) {
+				t.Fatalf("%v: got %x, expected %x", form, parsed.Bytes(), raw)
+			}
+			if parsed.Network != Esmeralda || parsed.Features != 0x01 {
+				t.Fatalf("%v: got network %v features %v", form, parsed.Network, parsed.Features)
+			}
+		}
+	}
+
+	parsed, err := Parse(hex.EncodeToString(dual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.IsDual() || parsed.ViewKey[0] != 0xbb || parsed.SpendKey[0] != 0xcc || string(parsed.PaymentID) != "invoice 42" {
+		t.Fatalf("dual address split wrong: %+v", parsed)
+	}
+	parsed, err = Parse(hex.EncodeToString(single))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.IsDual() || parsed.SpendKey[0] != 0xaa || parsed.PaymentID != nil {
+		t.Fatalf("single address split wrong: %+v", parsed)
+	}
+}
+
+func TestParseEmojiSeparators(t *testing.T) {
+	raw := testRaw(Esmeralda, nil, 0x01)
+	var out strings.Builder
+	for _, b := range raw {
+		out.WriteRune(emojiAlphabet[b])
+		out.WriteRune('\uFE0F')
+	}
+	if _, err := Parse(" " + out.String() + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEmoji(out.String()) || IsEmoji(testBase58(raw)) {
+		t.Fatal("IsEmoji mistook an emoji id for base58 or the other way round")
+	}
+	if _, err := Parse(testEmoji(raw) + "é"); !errors.Is(err, ErrInvalidEmoji) {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidEmoji)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	raw := testRaw(Esmeralda, nil, 0x10)
+	raw[5] ^= 0x01
+	for form, s := range map[string]string{"base58": testBase58(raw), "emoji": testEmoji(raw)} {
+		if _, err := Parse(s); !errors.Is(err, ErrInvalidChecksum) {
+			t.Fatalf("%v: got %v, expected %v", form, err, ErrInvalidChecksum)
+		}
+	}
+	// Bad hex falls through to base58, which '0' isn't part of
+	if _, err := Parse(hex.EncodeToString(raw)); err == nil {
+		t.Fatal("corrupted hex address parsed")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("  "); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("got %v, expected %v", err, ErrEmpty)
+	}
+	if _, err := Parse("12"); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidLength)
+	}
+	if _, err := Parse("12O0Il"); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidEncoding)
+	}
+	raw := testRaw(Esmeralda, nil, 0x10)
+	if _, err := FromBytes(raw[:len(raw)-1]); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidLength)
+	}
+	if _, err := Parse(testBase58(testRaw(Network(0x05), nil, 0x10))); !errors.Is(err, ErrUnknownNetwork) {
+		t.Fatalf("got %v, expected %v", err, ErrUnknownNetwork)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := testBase58(testRaw(Esmeralda, nil, 0x20))
+	if _, err := Validate(s, Esmeralda); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Validate(s, MainNet); !errors.Is(err, ErrWrongNetwork) {
+		t.Fatalf("got %v, expected %v", err, ErrWrongNetwork)
+	}
+	network, err := ParseNetwork("Esmeralda")
+	if err != nil || network != Esmeralda {
+		t.Fatalf("got %v %v", network, err)
+	}
+	if _, err = ParseNetwork("testnet"); err == nil {
+		t.Fatal("unknown network parsed")
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	for _, raw := range [][]byte{testRaw(Esmeralda, nil, 0x00), testRaw(MainNet, []byte("id"), 0x00, 0xff)} {
+		expected := testBase58(raw)
+		for _, s := range []string{expected, hex.EncodeToString(raw), testEmoji(raw), " " + expected + "\n"} {
+			canonical, err := Canonical(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical != expected {
+				t.Fatalf("%q: got %q, expected %q", s, canonical, expected)
+			}
+		}
+		// And it parses back to the same address
+		parsed, err := Parse(expected)
+		if err != nil || string(parsed.Bytes()) != string(raw) {
+			t.Fatalf("got %x %v, expected %x", parsed.Bytes(), err, raw)
+		}
+	}
+	if _, err := Canonical("not an address"); err == nil {
+		t.Fatal("bad address canonicalized")
+	}
+}
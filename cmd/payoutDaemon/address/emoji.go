@@ -0,0 +1,60 @@
+package address
+
+import "errors"
+
+// emojiAlphabet is Tari's emoji id alphabet, the emoji at index i stands for the byte i.  An emoji id is the binary
+// form of the address with every byte, checksum included, replaced by its emoji.
+var emojiAlphabet = [256]rune{
+	'🌀', '🌂', '🌈', '🌊', '🌋', '🌍', '🌙', '🌝', '🌞', '🌟', '🌠', '🌰', '🌴', '🌵', '🌷', '🌸',
+	'🌹', '🌻', '🌽', '🍀', '🍁', '🍄', '🍅', '🍆', '🍇', '🍈', '🍉', '🍊', '🍋', '🍌', '🍍', '🍎',
+	'🍐', '🍑', '🍒', '🍓', '🍔', '🍕', '🍗', '🍚', '🍞', '🍟', '🍠', '🍣', '🍦', '🍩', '🍪', '🍫',
+	'🍬', '🍭', '🍯', '🍰', '🍳', '🍴', '🍵', '🍶', '🍷', '🍸', '🍹', '🍺', '🍼', '🎀', '🎁', '🎂',
+	'🎃', '🎄', '🎈', '🎉', '🎒', '🎓', '🎠', '🎡', '🎢', '🎣', '🎤', '🎥', '🎧', '🎨', '🎩', '🎪',
+	'🎬', '🎭', '🎮', '🎰', '🎱', '🎲', '🎳', '🎵', '🎷', '🎸', '🎹', '🎺', '🎻', '🎼', '🎽', '🎾',
+	'🎿', '🏀', '🏁', '🏆', '🏈', '🏉', '🏠', '🏥', '🏦', '🏭', '🏰', '🐀', '🐉', '🐊', '🐌', '🐍',
+	'🐎', '🐐', '🐑', '🐓', '🐖', '🐗', '🐘', '🐙', '🐚', '🐛', '🐜', '🐝', '🐞', '🐢', '🐣', '🐨',
+	'🐩', '🐪', '🐬', '🐭', '🐮', '🐯', '🐰', '🐲', '🐳', '🐴', '🐵', '🐶', '🐷', '🐸', '🐺', '🐻',
+	'🐼', '🐽', '🐾', '👀', '👅', '👑', '👒', '👓', '👔', '👕', '👖', '👗', '👘', '👙', '👚', '👛',
+	'👞', '👟', '👠', '👡', '👢', '👣', '👹', '👻', '👽', '👾', '👿', '💀', '💄', '💈', '💉', '💊',
+	'💋', '💌', '💍', '💎', '💐', '💔', '💕', '💘', '💡', '💣', '💤', '💦', '💨', '💩', '💭', '💯',
+	'💰', '💳', '💸', '💺', '💻', '💼', '📈', '📉', '📌', '📎', '📚', '📝', '📡', '📣', '📱', '📷',
+	'🔋', '🔌', '🔎', '🔑', '🔔', '🔥', '🔦', '🔧', '🔨', '🔩', '🔪', '🔫', '🔬', '🔭', '🔮', '🔱',
+	'🗽', '😂', '😇', '😈', '😉', '😍', '😎', '😱', '😷', '🤢', '👍', '👶', '🚀', '🚁', '🚂', '🚚',
+	'🚑', '🚒', '🚓', '🛵', '🚗', '🚜', '🚢', '🚦', '🚧', '🚨', '🚪', '🚫', '🚲', '🚽', '🚿', '🧲',
+}
+
+var errEmoji = errors.New("not an emoji id character")
+
+var emojiIndex = func() map[rune]byte {
+	index := make(map[rune]byte, len(emojiAlphabet))
+	for i, emoji := range emojiAlphabet {
+		index[emoji] = byte(i)
+	}
+	return index
+}()
+
+// isEmoji reports whether s is written in emoji rather than base58 or hex, anything outside ASCII is taken as emoji
+func isEmoji(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeEmoji(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s)/4)
+	for _, r := range s {
+		// Some keyboards and copy and paste add variation selectors and joiners, they carry no data
+		if r == '\uFE0F' || r == '\uFE0E' || r == '\u200D' {
+			continue
+		}
+		b, ok := emojiIndex[r]
+		if !ok {
+			return nil, errEmoji
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* The admin API lets operators manage a running payoutDaemon over HTTP, instead of restarting it with --set-txn-halt
+	or poking redis by hand.  It is only served when --admin-listen is set, and every request needs
+	`Authorization: Bearer <ADMIN_TOKEN>`.
+
+	GET    /admin/halt                              is the halt key set
+	PUT    /admin/halt                              set the halt key
+	DELETE /admin/halt                              unset the halt key
+	GET    /admin/bypasses                          every address with a `bal_bypass_<address>` key
+	PUT    /admin/bypasses/{address}                pay the address next run regardless of its payout minimum
+	DELETE /admin/bypasses/{address}                remove the bypass
+	PUT    /admin/balances/{address}/valid          put the balance back into the payout rotation
+	PUT    /admin/balances/{address}/invalid        {"reason": "..."} take the balance out of the payout rotation
+	PUT    /admin/balances/{address}/payout-minimum {"payout_minimum": 1000000}
+	POST   /admin/runs                              start a payout run now, it is skipped if one is already going
+	GET    /admin/reviews?state=pending             the balance review queue, see the anomaly package
+	PUT    /admin/reviews/{id}/approve              {"note": "..."} let the balance be paid
+	PUT    /admin/reviews/{id}/reject               {"note": "..."} mark the balance invalid
+	GET    /admin/approvals?state=pending           payouts over the approval threshold, see approvals.go
+	PUT    /admin/approvals/{id}/approve            {"note": "..."} the next run pays it
+	PUT    /admin/approvals/{id}/reject             {"note": "..."} mark the balance invalid
+	GET    /admin/audit?limit=100                   the most recent audit log entries
+
+Every change is written to `audit_log`, the actor is taken from the `X-Admin-Actor` header and defaults to admin-api.
+*/
+
+type adminServer struct {
+	ctx          context.Context
+	milieu       *core.Milieu
+	token        string
+	maxBodyBytes int64
+}
+
+type adminResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (a *adminServer) respond(w http.ResponseWriter, status int, resp adminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (a *adminServer) fail(w http.ResponseWriter, err error) {
+	a.milieu.CaptureException(err)
+	a.milieu.Info(err.Error())
+	a.respond(w, http.StatusInternalServerError, adminResponse{Status: "error", Message: err.Error()})
+}
+
+// authenticate wraps a handler so it only runs for requests carrying the admin token
+func (a *adminServer) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			a.respond(w, http.StatusUnauthorized, adminResponse{Status: "error", Message: "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// actor is who made the request, for the audit log
+func (a *adminServer) actor(r *http.Request) string {
+	if actor := strings.TrimSpace(r.Header.Get("X-Admin-Actor")); actor != "" {
+		return actor
+	}
+	return "admin-api"
+}
+
+// audit records a change made through the API, the change has already happened so a failure here is only reported
+func (a *adminServer) audit(w http.ResponseWriter, r *http.Request, action string, subject string, detail string) {
+	actor := a.actor(r)
+	a.milieu.Info(fmt.Sprintf("Admin API: %v %v by %v: %v", action, subject, actor, detail))
+	err := store.CreateAuditEntry(r.Context(), sql.AuditEntry{
+		Actor:   actor,
+		Action:  action,
+		Subject: subject,
+		Detail:  detail,
+	})
+	if err != nil {
+		a.fail(w, fmt.Errorf("%v %v was applied but could not be written to the audit log: %w", action, subject, err))
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Message: detail})
+}
+
+// decode reads a JSON body into v, an empty body leaves v as it is, responding with a 400 if it can't
+func (a *adminServer) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: fmt.Sprintf("bad request body: %v", err)})
+		return false
+	}
+	return true
+}
+
+// balanceID looks up the balance for the {address} in the path, in any form, responding with a 404 if there isn't one.
+// Balances are stored under the canonical address, one stored before that is found under the form it was given in.
+func (a *adminServer) balanceID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	addr := r.PathValue("address")
+	var id uint64
+	err := sql.ErrNotFound
+	if canonical, parseErr := address.Canonical(addr); parseErr == nil {
+		id, err = store.GetBalanceIDByAddress(r.Context(), canonical)
+	}
+	if errors.Is(err, sql.ErrNotFound) {
+		id, err = store.GetBalanceIDByAddress(r.Context(), addr)
+	}
+	if errors.Is(err, sql.ErrNotFound) {
+		a.respond(w, http.StatusNotFound, adminResponse{Status: "error", Message: "no balance for this address"})
+		return 0, false
+	}
+	if err != nil {
+		a.fail(w, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *adminServer) handleGetHalt(w http.ResponseWriter, r *http.Request) {
+	count, err := a.milieu.GetRedis().Exists(context.Background(), haltTxnKey).Result()
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Data: map[string]bool{"halted": count != 0}})
+}
+
+func (a *adminServer) handleSetHalt(w http.ResponseWriter, r *http.Request) {
+	if err := a.milieu.GetRedis().Set(context.Background(), haltTxnKey, 1, 0).Err(); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "set_halt", haltTxnKey, "payouts halted")
+}
+
+func (a *adminServer) handleUnsetHalt(w http.ResponseWriter, r *http.Request) {
+	if err := a.milieu.GetRedis().Del(context.Background(), haltTxnKey).Err(); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "unset_halt", haltTxnKey, "payouts resumed")
+}
+
+func (a *adminServer) handleListBypasses(w http.ResponseWriter, r *http.Request) {
+	addresses := make([]string, 0)
+	iter := a.milieu.GetRedis().Scan(context.Background(), 0, "bal_bypass_*", 1000).Iterator()
+	for iter.Next(context.Background()) {
+		addresses = append(addresses, strings.TrimPrefix(iter.Val(), "bal_bypass_"))
+	}
+	if err := iter.Err(); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Data: addresses})
+}
+
+func (a *adminServer) handleAddBypass(w http.ResponseWriter, r *http.Request) {
+	parsed, err := parsePayoutAddress(r.PathValue("address"))
+	if err != nil {
+		a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	addr := parsed.String()
+	if err = a.milieu.GetRedis().Set(context.Background(), bypassKey(addr), 1, 0).Err(); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "add_bypass", fmt.Sprintf("address:%v", addr), "paid next run regardless of payout minimum")
+}
+
+func (a *adminServer) handleRemoveBypass(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("address")
+	// An address that doesn't parse can still have a bypass left over from before addresses were checked
+	if canonical, err := address.Canonical(addr); err == nil {
+		addr = canonical
+	}
+	if err := a.milieu.GetRedis().Del(context.Background(), bypassKey(addr)).Err(); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "remove_bypass", fmt.Sprintf("address:%v", addr), "bypass removed")
+}
+
+func (a *adminServer) handleMarkValid(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.balanceID(w, r)
+	if !ok {
+		return
+	}
+	if err := store.MarkBalanceValid(r.Context(), id); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "mark_balance_valid", fmt.Sprintf("balance:%v", id), "balance marked valid")
+}
+
+func (a *adminServer) handleMarkInvalid(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !a.decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: "a reason is required"})
+		return
+	}
+	id, ok := a.balanceID(w, r)
+	if !ok {
+		return
+	}
+	if err := store.MarkBalanceInvalid(r.Context(), id, req.Reason); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "mark_balance_invalid", fmt.Sprintf("balance:%v", id), fmt.Sprintf("balance marked invalid: %v", req.Reason))
+}
+
+func (a *adminServer) handleSetPayoutMinimum(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PayoutMinimum *uint64 `json:"payout_minimum"`
+	}
+	if !a.decode(w, r, &req) {
+		return
+	}
+	if req.PayoutMinimum == nil {
+		a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: "payout_minimum is required"})
+		return
+	}
+	id, ok := a.balanceID(w, r)
+	if !ok {
+		return
+	}
+	if err := store.SetPayoutMinimum(r.Context(), id, *req.PayoutMinimum); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "set_payout_minimum", fmt.Sprintf("balance:%v", id), fmt.Sprintf("payout minimum set to %v", *req.PayoutMinimum))
+}
+
+func (a *adminServer) handleTriggerRun(w http.ResponseWriter, r *http.Request) {
+	// Only a hint, performPayouts takes the lock itself and quietly skips if a cron tick beat it to it
+	if !runMutex.TryLock() {
+		a.respond(w, http.StatusConflict, adminResponse{Status: "error", Message: "a payout run is already in progress"})
+		return
+	}
+	runMutex.Unlock()
+	if a.ctx.Err() != nil {
+		a.respond(w, http.StatusServiceUnavailable, adminResponse{Status: "error", Message: "payoutDaemon is shutting down"})
+		return
+	}
+	go performPayouts(a.ctx, a.milieu)
+	a.audit(w, r, "trigger_run", "payouts", "payout run started")
+}
+
+func (a *adminServer) handleListReviews(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = sql.ReviewPending
+	}
+	reviews, err := store.GetReviews(r.Context(), state)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Data: reviews})
+}
+
+// resolveReview moves the {id} review in the path out of pending, responding with what went wrong if it couldn't
+func (a *adminServer) resolveReview(w http.ResponseWriter, r *http.Request, state string) (sql.ReviewEntry, string, bool) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if !a.decode(w, r, &req) {
+		return sql.ReviewEntry{}, "", false
+	}
+	reviewID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: "review ID must be a number"})
+		return sql.ReviewEntry{}, "", false
+	}
+	review, err := store.GetReview(r.Context(), reviewID)
+	if errors.Is(err, sql.ErrNotFound) {
+		a.respond(w, http.StatusNotFound, adminResponse{Status: "error", Message: "no such review"})
+		return review, "", false
+	}
+	if err != nil {
+		a.fail(w, err)
+		return review, "", false
+	}
+	resolved, err := store.ResolveReview(r.Context(), reviewID, state, a.actor(r), req.Note)
+	if err != nil {
+		a.fail(w, err)
+		return review, "", false
+	}
+	if !resolved {
+		a.respond(w, http.StatusConflict, adminResponse{Status: "error", Message: fmt.Sprintf("review is already %v", review.State)})
+		return review, "", false
+	}
+	return review, req.Note, true
+}
+
+func (a *adminServer) handleApproveReview(w http.ResponseWriter, r *http.Request) {
+	review, note, ok := a.resolveReview(w, r, sql.ReviewApproved)
+	if !ok {
+		return
+	}
+	a.audit(w, r, "approve_review", fmt.Sprintf("balance:%v", review.BalanceID), fmt.Sprintf("review %v approved (%v): %v", review.ID, review.Reasons, note))
+}
+
+func (a *adminServer) handleRejectReview(w http.ResponseWriter, r *http.Request) {
+	review, note, ok := a.resolveReview(w, r, sql.ReviewRejected)
+	if !ok {
+		return
+	}
+	if err := store.MarkBalanceInvalid(r.Context(), review.BalanceID, fmt.Sprintf("review %v rejected: %v", review.ID, note)); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(w, r, "reject_review", fmt.Sprintf("balance:%v", review.BalanceID), fmt.Sprintf("review %v rejected (%v), balance marked invalid: %v", review.ID, review.Reasons, note))
+}
+
+func (a *adminServer) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = sql.ApprovalPending
+	}
+	approvals, err := store.GetApprovals(r.Context(), state)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Data: approvals})
+}
+
+// handleResolveApproval builds the approve and reject handlers, they only differ by the state they move to
+func (a *adminServer) handleResolveApproval(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Note string `json:"note"`
+		}
+		if !a.decode(w, r, &req) {
+			return
+		}
+		approvalID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: "approval ID must be a number"})
+			return
+		}
+		approval, err := resolveApproval(r.Context(), approvalID, state, a.actor(r), req.Note)
+		switch {
+		case errors.Is(err, sql.ErrNotFound):
+			a.respond(w, http.StatusNotFound, adminResponse{Status: "error", Message: "no such approval"})
+			return
+		case errors.Is(err, errApprovalNotPending):
+			a.respond(w, http.StatusConflict, adminResponse{Status: "error", Message: err.Error()})
+			return
+		case err != nil:
+			a.fail(w, err)
+			return
+		}
+		action, detail := approvalAudit(approval, state, req.Note)
+		a.audit(w, r, action, fmt.Sprintf("balance:%v", approval.BalanceID), detail)
+	}
+}
+
+func (a *adminServer) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			a.respond(w, http.StatusBadRequest, adminResponse{Status: "error", Message: "limit must be a positive number"})
+			return
+		}
+		limit = parsed
+	}
+	entries, err := store.GetAuditEntries(r.Context(), limit)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.respond(w, http.StatusOK, adminResponse{Status: "ok", Data: entries})
+}
+
+// handler routes every admin endpoint, each behind the token
+func (a *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/halt", a.authenticate(a.handleGetHalt))
+	mux.HandleFunc("PUT /admin/halt", a.authenticate(a.handleSetHalt))
+	mux.HandleFunc("DELETE /admin/halt", a.authenticate(a.handleUnsetHalt))
+	mux.HandleFunc("GET /admin/bypasses", a.authenticate(a.handleListBypasses))
+	mux.HandleFunc("PUT /admin/bypasses/{address}", a.authenticate(a.handleAddBypass))
+	mux.HandleFunc("DELETE /admin/bypasses/{address}", a.authenticate(a.handleRemoveBypass))
+	mux.HandleFunc("PUT /admin/balances/{address}/valid", a.authenticate(a.handleMarkValid))
+	mux.HandleFunc("PUT /admin/balances/{address}/invalid", a.authenticate(a.handleMarkInvalid))
+	mux.HandleFunc("PUT /admin/balances/{address}/payout-minimum", a.authenticate(a.handleSetPayoutMinimum))
+	mux.HandleFunc("POST /admin/runs", a.authenticate(a.handleTriggerRun))
+	mux.HandleFunc("GET /admin/reviews", a.authenticate(a.handleListReviews))
+	mux.HandleFunc("PUT /admin/reviews/{id}/approve", a.authenticate(a.handleApproveReview))
+	mux.HandleFunc("PUT /admin/reviews/{id}/reject", a.authenticate(a.handleRejectReview))
+	mux.HandleFunc("GET /admin/approvals", a.authenticate(a.handleListApprovals))
+	mux.HandleFunc("PUT /admin/approvals/{id}/approve", a.authenticate(a.handleResolveApproval(sql.ApprovalApproved)))
+	mux.HandleFunc("PUT /admin/approvals/{id}/reject", a.authenticate(a.handleResolveApproval(sql.ApprovalRejected)))
+	mux.HandleFunc("GET /admin/audit", a.authenticate(a.handleGetAudit))
+	return mux
+}
+
+// serveAdmin exposes the admin API on addr in the background, runs started through it are cancelled with ctx
+func serveAdmin(ctx context.Context, milieu *core.Milieu, addr string, token string) *http.Server {
+	a := &adminServer{ctx: ctx, milieu: milieu, token: token, maxBodyBytes: 4096}
+	server := &http.Server{Addr: addr, Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+		}
+	}()
+	return server
+}
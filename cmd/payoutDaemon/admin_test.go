@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "s3cret-admin-token"
+
+// adminRequest sends a request through the admin API's routes, authorization is sent as the header as given
+func adminRequest(t *testing.T, handler http.Handler, method string, path string, authorization string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	r.Header.Set("X-Admin-Actor", "tester")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	milieu, memoryStore, _ := setupDaemon(t)
+	handler := (&adminServer{ctx: context.Background(), milieu: milieu, token: testAdminToken, maxBodyBytes: 4096}).handler()
+	balanceID, addr := addTestBalance(t, memoryStore, 1, 100000)
+	path := "/admin/balances/" + addr + "/invalid"
+
+	for _, authorization := range []string{"", testAdminToken, "Basic " + testAdminToken, "Bearer", "Bearer ", "Bearer s3cret", "Bearer " + testAdminToken + "x", "bearer " + testAdminToken} {
+		w := adminRequest(t, handler, http.MethodPut, path, authorization, `{"reason": "test"}`)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: got %v, expected %v", authorization, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if row, _ := memoryStore.Balance(balanceID); !row.Valid {
+		t.Fatal("an unauthorized request changed the balance")
+	}
+	entries, err := memoryStore.GetAuditEntries(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("got %v audit entries from unauthorized requests", len(entries))
+	}
+
+	w := adminRequest(t, handler, http.MethodPut, path, "Bearer "+testAdminToken, `{"reason": "test"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %v: %v", w.Code, w.Body.String())
+	}
+	if row, _ := memoryStore.Balance(balanceID); row.Valid {
+		t.Fatal("balance is still valid")
+	}
+	if entries, err = memoryStore.GetAuditEntries(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "tester" || entries[0].Action != "mark_balance_invalid" {
+		t.Fatalf("audit log: %+v", entries)
+	}
+}
+
+func TestAdminBalanceLookup(t *testing.T) {
+	milieu, memoryStore, _ := setupDaemon(t)
+	handler := (&adminServer{ctx: context.Background(), milieu: milieu, token: testAdminToken, maxBodyBytes: 4096}).handler()
+	authorization := "Bearer " + testAdminToken
+
+	// Stored under the canonical form, found from any other
+	hexAddress := testAddress(t, 1)
+	canonical, err := address.Canonical(hexAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalID := memoryStore.AddBalance(sql.BalanceSqlRow{Address: canonical, Balance: 100000, Valid: false})
+	// Stored before addresses were canonicalized, found under the form it was given in
+	legacyID, legacyAddress := addTestBalance(t, memoryStore, 2, 100000)
+	if err = memoryStore.MarkBalanceInvalid(context.Background(), legacyID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for balanceID, addr := range map[uint64]string{canonicalID: hexAddress, legacyID: legacyAddress} {
+		if w := adminRequest(t, handler, http.MethodPut, "/admin/balances/"+addr+"/valid", authorization, ""); w.Code != http.StatusOK {
+			t.Fatalf("%v: got %v: %v", addr, w.Code, w.Body.String())
+		}
+		if row, _ := memoryStore.Balance(balanceID); !row.Valid {
+			t.Fatalf("balance %v is still invalid", balanceID)
+		}
+	}
+	if w := adminRequest(t, handler, http.MethodPut, "/admin/balances/"+testAddress(t, 3)+"/valid", authorization, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("got %v, expected %v", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,152 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"strings"
+	"time"
+)
+
+/* anomaly looks for balances that don't look like the rest of their history before they are paid, anything it flags
+	goes into `balance_review_queue` for a human instead of the wallet.
+
+Each check is off when its threshold is 0:
+	HistoryMultiple flags a balance worth more than that many times the largest payout it has had, once it has been
+		paid at least MinHistory times.  FirstPayoutMax flags a balance above it with less history than that.
+	JumpMax flags a balance credited more than it within JumpWindow, see sql.GetCreditedSince.
+	NewAddressBurst flags every balance created within BurstWindow when more than that many were.
+A balance waiting on a review is never paid, whether or not any check is on.  One whose latest review was approved
+	after it last increased is let through, it has already been looked at.
+*/
+
+// Check names, each reason from Check starts with one
+const (
+	ExceedsHistory   = "exceeds_history"
+	LargeFirstPayout = "large_first_payout"
+	SuddenJump       = "sudden_jump"
+	NewAddressBurst  = "new_address_burst"
+)
+
+const (
+	defaultMinHistory  = 3
+	defaultJumpWindow  = 24 * time.Hour
+	defaultBurstWindow = time.Hour
+)
+
+type Thresholds struct {
+	HistoryMultiple float64
+	MinHistory      uint64
+	FirstPayoutMax  uint64
+	JumpMax         uint64
+	JumpWindow      time.Duration
+	NewAddressBurst int
+	BurstWindow     time.Duration
+}
+
+// Enabled reports whether any check is turned on, there is no need to load anything otherwise
+func (t Thresholds) Enabled() bool {
+	return t.HistoryMultiple > 0 || t.FirstPayoutMax > 0 || t.JumpMax > 0 || t.NewAddressBurst > 0
+}
+
+// Checker holds everything the checks compare a balance against, loaded once per run
+type Checker struct {
+	thresholds Thresholds
+	history    map[uint64]sql.PayoutHistory
+	credited   map[uint64]uint64
+	reviews    map[uint64]sql.ReviewEntry
+	burst      bool
+	now        time.Time
+}
+
+// NewChecker loads the reviews and whatever history the checks need, balances is every balance in the run
+func NewChecker(ctx context.Context, store sql.Store, thresholds Thresholds, balances []sql.BalanceSqlRow) (*Checker, error) {
+	if thresholds.MinHistory == 0 {
+		thresholds.MinHistory = defaultMinHistory
+	}
+	if thresholds.JumpWindow == 0 {
+		thresholds.JumpWindow = defaultJumpWindow
+	}
+	if thresholds.BurstWindow == 0 {
+		thresholds.BurstWindow = defaultBurstWindow
+	}
+	c := &Checker{
+		thresholds: thresholds,
+		history:    make(map[uint64]sql.PayoutHistory),
+		credited:   make(map[uint64]uint64),
+		reviews:    make(map[uint64]sql.ReviewEntry),
+		now:        time.Now(),
+	}
+	reviews, err := store.GetLatestReviews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, review := range reviews {
+		c.reviews[review.BalanceID] = review
+	}
+	if !thresholds.Enabled() {
+		return c, nil
+	}
+	history, err := store.GetPayoutHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range history {
+		c.history[row.BalanceID] = row
+	}
+	credited, err := store.GetCreditedSince(ctx, c.now.Add(-thresholds.JumpWindow))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range credited {
+		c.credited[row.BalanceID] = row.Amount
+	}
+	if thresholds.NewAddressBurst > 0 {
+		created := 0
+		for _, balance := range balances {
+			if c.now.Sub(balance.DateAdded) <= thresholds.BurstWindow {
+				created += 1
+			}
+		}
+		c.burst = created > thresholds.NewAddressBurst
+	}
+	return c, nil
+}
+
+// InReview reports whether the balance is waiting on a review, it must not be paid until someone resolves it
+func (c *Checker) InReview(balance sql.BalanceSqlRow) bool {
+	review, ok := c.reviews[balance.ID]
+	return ok && review.State == sql.ReviewPending
+}
+
+// Check returns why the balance looks wrong, or nothing if it can be paid
+func (c *Checker) Check(balance sql.BalanceSqlRow) []string {
+	t := c.thresholds
+	if !t.Enabled() {
+		return nil
+	}
+	if review, ok := c.reviews[balance.ID]; ok && review.State == sql.ReviewApproved && review.DateResolved.After(balance.DateBalanceIncreased) {
+		return nil
+	}
+	reasons := make([]string, 0)
+	history := c.history[balance.ID]
+	if history.Count >= t.MinHistory {
+		if t.HistoryMultiple > 0 && float64(balance.Balance) > t.HistoryMultiple*float64(history.Largest) {
+			reasons = append(reasons, fmt.Sprintf("%v: %v is over %v times the largest of %v payouts (%v)", ExceedsHistory, balance.Balance, t.HistoryMultiple, history.Count, history.Largest))
+		}
+	} else if t.FirstPayoutMax > 0 && balance.Balance > t.FirstPayoutMax {
+		reasons = append(reasons, fmt.Sprintf("%v: %v with only %v previous payouts, limit is %v", LargeFirstPayout, balance.Balance, history.Count, t.FirstPayoutMax))
+	}
+	if t.JumpMax > 0 && c.credited[balance.ID] > t.JumpMax {
+		reasons = append(reasons, fmt.Sprintf("%v: credited %v in the last %v, limit is %v", SuddenJump, c.credited[balance.ID], t.JumpWindow, t.JumpMax))
+	}
+	if c.burst && c.now.Sub(balance.DateAdded) <= t.BurstWindow {
+		reasons = append(reasons, fmt.Sprintf("%v: more than %v balances created in the last %v", NewAddressBurst, t.NewAddressBurst, t.BurstWindow))
+	}
+	return reasons
+}
+
+// Reasons joins the reasons from Check for storing in the review queue
+func Reasons(reasons []string) string {
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,159 @@
+package anomaly
+
+import (
+	"context"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hasReason reports whether one of the reasons is from the named check
+func hasReason(reasons []string, check string) bool {
+	for _, reason := range reasons {
+		if strings.HasPrefix(reason, check+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// oldBalance seeds a balance added well outside any window
+func oldBalance(store *sql.MemoryStore, address string, balance uint64) sql.BalanceSqlRow {
+	added := time.Now().Add(-30 * 24 * time.Hour)
+	row := sql.BalanceSqlRow{Address: address, Balance: balance, Valid: true, DateAdded: added, DateBalanceIncreased: added, DateLastUpdated: added}
+	row.ID = store.AddBalance(row)
+	return row
+}
+
+func paid(t *testing.T, store *sql.MemoryStore, balanceID uint64, amounts ...uint64) {
+	t.Helper()
+	ctx := context.Background()
+	for i, amount := range amounts {
+		txn, err := store.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.CreateNewTransaction(ctx, txn, balanceID*100+uint64(i), true, "", balanceID, 1, amount, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	store := sql.NewMemoryStore()
+	balance := oldBalance(store, "a", 1<<40)
+	checker, err := NewChecker(context.Background(), store, Thresholds{}, []sql.BalanceSqlRow{balance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasons := checker.Check(balance); len(reasons) != 0 {
+		t.Fatalf("got %v", reasons)
+	}
+}
+
+func TestCheckHistory(t *testing.T) {
+	store := sql.NewMemoryStore()
+	regular := oldBalance(store, "regular", 3000)
+	paid(t, store, regular.ID, 500, 1000, 800)
+	spiked := oldBalance(store, "spiked", 5000)
+	paid(t, store, spiked.ID, 500, 1000, 800)
+	fresh := oldBalance(store, "fresh", 2500)
+	paid(t, store, fresh.ID, 100)
+	balances := []sql.BalanceSqlRow{regular, spiked, fresh}
+
+	checker, err := NewChecker(context.Background(), store, Thresholds{HistoryMultiple: 4, FirstPayoutMax: 2000}, balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasons := checker.Check(regular); len(reasons) != 0 {
+		t.Fatalf("regular: got %v", reasons)
+	}
+	if reasons := checker.Check(spiked); len(reasons) != 1 || !hasReason(reasons, ExceedsHistory) {
+		t.Fatalf("spiked: got %v", reasons)
+	}
+	// Not enough history to compare against, the first payout limit applies instead
+	if reasons := checker.Check(fresh); len(reasons) != 1 || !hasReason(reasons, LargeFirstPayout) {
+		t.Fatalf("fresh: got %v", reasons)
+	}
+}
+
+func TestCheckJumpAndBurst(t *testing.T) {
+	ctx := context.Background()
+	store := sql.NewMemoryStore()
+	jumped := oldBalance(store, "jumped", 100)
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.IncreaseBalance(ctx, txn, jumped.ID, 9000, sql.LedgerCredit, "share"); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	balances := []sql.BalanceSqlRow{jumped}
+	for _, address := range []string{"new1", "new2", "new3"} {
+		row := sql.BalanceSqlRow{Address: address, Balance: 10, Valid: true}
+		row.ID = store.AddBalance(row)
+		row, _ = store.Balance(row.ID)
+		balances = append(balances, row)
+	}
+
+	checker, err := NewChecker(ctx, store, Thresholds{JumpMax: 5000, NewAddressBurst: 2}, balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasons := checker.Check(jumped); len(reasons) != 1 || !hasReason(reasons, SuddenJump) {
+		t.Fatalf("jumped: got %v", reasons)
+	}
+	for _, balance := range balances[1:] {
+		if reasons := checker.Check(balance); len(reasons) != 1 || !hasReason(reasons, NewAddressBurst) {
+			t.Fatalf("%v: got %v", balance.Address, reasons)
+		}
+	}
+
+	// Three new balances isn't a burst when three are allowed
+	checker, err = NewChecker(ctx, store, Thresholds{NewAddressBurst: 3}, balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasons := checker.Check(balances[1]); len(reasons) != 0 {
+		t.Fatalf("got %v", reasons)
+	}
+}
+
+func TestCheckReviews(t *testing.T) {
+	ctx := context.Background()
+	store := sql.NewMemoryStore()
+	approved := oldBalance(store, "approved", 5000)
+	pending := oldBalance(store, "pending", 5000)
+	thresholds := Thresholds{FirstPayoutMax: 1000}
+	for _, balance := range []sql.BalanceSqlRow{approved, pending} {
+		if _, err := store.CreateReview(ctx, balance.ID, balance.Address, balance.Balance, "large_first_payout"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.ResolveReview(ctx, 1, sql.ReviewApproved, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := NewChecker(ctx, store, thresholds, []sql.BalanceSqlRow{approved, pending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checker.InReview(approved) || !checker.InReview(pending) {
+		t.Fatal("InReview doesn't match the review states")
+	}
+	if reasons := checker.Check(approved); len(reasons) != 0 {
+		t.Fatalf("approved: got %v", reasons)
+	}
+	// Credited again since it was approved, it has to be looked at again
+	approved.DateBalanceIncreased = time.Now().Add(time.Minute)
+	if reasons := checker.Check(approved); !hasReason(reasons, LargeFirstPayout) {
+		t.Fatalf("approved then credited: got %v", reasons)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/events"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+)
+
+/* Payouts of more than --approval-threshold are not sent until an operator has signed them off.  The first run to see
+	one puts it into `payout_approvals` as pending and publishes an `approval_requested` event, every run after that
+	skips it until it is approved or rejected, with --approve-payout / --reject-payout or through the admin API.
+
+An approved payout is picked up by the next run, and the approval is consumed in the same PSQL txn that reserves it.
+	If the balance has grown past the approved amount by then the approval is expired and a new one is asked for.
+	Rejecting a payout marks the balance invalid, otherwise it would be back in the queue on the next run.
+*/
+
+var approvalThreshold uint64
+
+var errApprovalNotPending = errors.New("payout approval is not pending")
+
+// approvalFor decides whether a balance over the approval threshold can be paid this run, returning the approval to
+// consume if it can.  Anything else is left with a pending approval for an operator.
+func approvalFor(ctx context.Context, milieu *core.Milieu, balance sql.BalanceSqlRow, open map[uint64]sql.PayoutApproval) (uint64, bool) {
+	approval, ok := open[balance.ID]
+	if ok && approval.State == sql.ApprovalApproved {
+		if balance.Balance <= approval.Amount {
+			return approval.ID, true
+		}
+		milieu.Info(fmt.Sprintf("Balance %v has grown to %v since %v was approved, asking again", balance.ID, balance.Balance, approval.Amount))
+		if isDryRun {
+			return 0, false
+		}
+		if _, err := store.ExpireApproval(ctx, approval.ID); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+			return 0, false
+		}
+		ok = false
+	}
+	if ok || isDryRun {
+		return 0, false
+	}
+	created, err := store.CreateApproval(ctx, balance.ID, balance.Address, balance.Balance)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return 0, false
+	}
+	if created {
+		events.Publish(milieu, eventChannel, events.Event{
+			Type:   events.ApprovalRequested,
+			Amount: balance.Balance,
+			Reason: fmt.Sprintf("balance %v (%v) is over the approval threshold of %v", balance.ID, balance.Address, approvalThreshold),
+		})
+	}
+	return 0, false
+}
+
+// resolveApproval approves or rejects a pending payout, a rejection also takes the balance out of the payout rotation.
+// Recording it in the audit log is left to the caller.
+func resolveApproval(ctx context.Context, approvalID uint64, state string, actor string, note string) (sql.PayoutApproval, error) {
+	approval, err := store.GetApproval(ctx, approvalID)
+	if err != nil {
+		return approval, err
+	}
+	resolved, err := store.ResolveApproval(ctx, approvalID, state, actor, note)
+	if err != nil {
+		return approval, err
+	}
+	if !resolved {
+		return approval, fmt.Errorf("%w, it is %v", errApprovalNotPending, approval.State)
+	}
+	if state == sql.ApprovalRejected {
+		if err = store.MarkBalanceInvalid(ctx, approval.BalanceID, fmt.Sprintf("payout approval %v rejected: %v", approvalID, note)); err != nil {
+			return approval, err
+		}
+	}
+	return approval, nil
+}
+
+// approvalAudit describes a resolved approval for the audit log, returning the action and detail
+func approvalAudit(approval sql.PayoutApproval, state string, note string) (string, string) {
+	action := "approve_payout"
+	if state == sql.ApprovalRejected {
+		action = "reject_payout"
+	}
+	return action, fmt.Sprintf("payout approval %v of %v to %v %v: %v", approval.ID, approval.Amount, approval.Address, state, note)
+}
+
+// resolveApprovalFromCLI is --approve-payout and --reject-payout
+func resolveApprovalFromCLI(ctx context.Context, milieu *core.Milieu, approvalID uint64, state string, note string) {
+	actor := getEnv("USER", "payoutDaemon")
+	approval, err := resolveApproval(ctx, approvalID, state, actor, note)
+	if err != nil {
+		milieu.Fatal(fmt.Sprintf("Unable to resolve payout approval %v: %v", approvalID, err))
+	}
+	action, detail := approvalAudit(approval, state, note)
+	milieu.Info(detail)
+	recordAudit(ctx, milieu, actor, action, fmt.Sprintf("balance:%v", approval.BalanceID), detail)
+}
+
+// printApprovals lists the pending approvals for --list-approvals
+func printApprovals(ctx context.Context, milieu *core.Milieu) {
+	approvals, err := store.GetApprovals(ctx, sql.ApprovalPending)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	fmt.Printf("%d payouts waiting on approval\n", len(approvals))
+	for _, approval := range approvals {
+		fmt.Printf("  %-8v balance %-8v %-16v %v (since %v)\n", approval.ID, approval.BalanceID, approval.Amount, approval.Address, approval.DateAdded.Format("2006-01-02 15:04"))
+	}
+}
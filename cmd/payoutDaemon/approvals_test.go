@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"testing"
+)
+
+// pendingApproval returns the only pending approval, failing if there isn't exactly one
+func pendingApproval(t *testing.T, memoryStore *sql.MemoryStore) sql.PayoutApproval {
+	t.Helper()
+	approvals, err := memoryStore.GetApprovals(context.Background(), sql.ApprovalPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 {
+		t.Fatalf("got %v pending approvals, expected 1", len(approvals))
+	}
+	return approvals[0]
+}
+
+func TestApprovalRequiredOverThreshold(t *testing.T) {
+	ctx := context.Background()
+	milieu, memoryStore, _ := setupDaemon(t)
+	approvalThreshold = 150000
+	smallID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	largeID, _ := addTestBalance(t, memoryStore, 2, 200000)
+
+	// Under the threshold is paid, over it waits on an approval, and a second run doesn't ask again
+	performPayouts(ctx, milieu)
+	performPayouts(ctx, milieu)
+	if balance := balanceOf(t, memoryStore, smallID); balance != 0 {
+		t.Fatalf("small balance is %v", balance)
+	}
+	if balance := balanceOf(t, memoryStore, largeID); balance != 200000 {
+		t.Fatalf("large balance is %v, expected it unpaid", balance)
+	}
+	approval := pendingApproval(t, memoryStore)
+	if approval.BalanceID != largeID || approval.Amount != 200000 {
+		t.Fatalf("approval: %+v", approval)
+	}
+
+	if _, err := resolveApproval(ctx, approval.ID, sql.ApprovalApproved, "tester", "checked"); err != nil {
+		t.Fatal(err)
+	}
+	performPayouts(ctx, milieu)
+	if balance := balanceOf(t, memoryStore, largeID); balance != 0 {
+		t.Fatalf("large balance is %v after approval", balance)
+	}
+	approval, err := memoryStore.GetApproval(ctx, approval.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approval.State != sql.ApprovalPaid || approval.BatchID != recipientOf(t, memoryStore, largeID).BatchID {
+		t.Fatalf("approval: %+v", approval)
+	}
+	// Consumed, it can't be resolved again
+	if _, err = resolveApproval(ctx, approval.ID, sql.ApprovalRejected, "tester", "too late"); !errors.Is(err, errApprovalNotPending) {
+		t.Fatalf("got %v, expected %v", err, errApprovalNotPending)
+	}
+}
+
+func TestApprovalExpiresWhenBalanceGrows(t *testing.T) {
+	ctx := context.Background()
+	milieu, memoryStore, _ := setupDaemon(t)
+	approvalThreshold = 150000
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 200000)
+
+	performPayouts(ctx, milieu)
+	approved := pendingApproval(t, memoryStore)
+	if _, err := resolveApproval(ctx, approved.ID, sql.ApprovalApproved, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	txn, err := memoryStore.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = memoryStore.IncreaseBalance(ctx, txn, balanceID, 50000, sql.LedgerCredit, "share"); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// More than was approved, the approval is expired and a new one asked for the new amount
+	performPayouts(ctx, milieu)
+	if balance := balanceOf(t, memoryStore, balanceID); balance != 250000 {
+		t.Fatalf("balance is %v, expected it unpaid", balance)
+	}
+	if approved, err = memoryStore.GetApproval(ctx, approved.ID); err != nil || approved.State != sql.ApprovalExpired {
+		t.Fatalf("approval: %+v %v", approved, err)
+	}
+	if pending := pendingApproval(t, memoryStore); pending.Amount != 250000 {
+		t.Fatalf("approval: %+v", pending)
+	}
+}
+
+func TestApprovalRejected(t *testing.T) {
+	ctx := context.Background()
+	milieu, memoryStore, _ := setupDaemon(t)
+	approvalThreshold = 150000
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 200000)
+
+	performPayouts(ctx, milieu)
+	approval := pendingApproval(t, memoryStore)
+	if _, err := resolveApproval(ctx, approval.ID, sql.ApprovalRejected, "tester", "looks wrong"); err != nil {
+		t.Fatal(err)
+	}
+	// Out of the rotation, so no new approval is asked for either
+	performPayouts(ctx, milieu)
+	row, _ := memoryStore.Balance(balanceID)
+	if row.Valid || row.Balance != 200000 {
+		t.Fatalf("balance: %+v", row)
+	}
+	approvals, err := memoryStore.GetApprovals(ctx, sql.ApprovalPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 0 {
+		t.Fatalf("got %v pending approvals after a rejection", len(approvals))
+	}
+}
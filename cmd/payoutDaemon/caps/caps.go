@@ -0,0 +1,101 @@
+package caps
+
+import (
+	"context"
+	"fmt"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"time"
+)
+
+/* caps puts a ceiling on how much payoutDaemon can hand to the wallet, so a bad credit into `balances` can't drain the
+	hot wallet in one go.
+
+There are four limits, all in microTari with the fee included, and 0 turns a limit off:
+	PerBatch is a single wallet send, one chunk of --batch-size recipients.
+	PerRun is everything a single payout run reserves.
+	Daily is everything handed to the wallet over the last 24 hours, across all addresses.
+	PerAddressDaily is the same, but for each balance on its own.
+A run is checked as a whole before any balance is debited, and each chunk again right before it goes to the wallet, so
+	a resumed batch or a change to the limits mid-run is still caught.  What counts as already spent comes from
+	`payment_batch_recipients`, see sql.GetSpendSince.  The caller is expected to halt payouts on a Breach.
+*/
+
+// Window is how far back Daily and PerAddressDaily look
+const Window = 24 * time.Hour
+
+// Cap names, for Breach and metrics
+const (
+	PerBatch        = "per_batch"
+	PerRun          = "per_run"
+	Daily           = "daily"
+	PerAddressDaily = "per_address_daily"
+)
+
+type Limits struct {
+	PerBatch        uint64
+	PerRun          uint64
+	Daily           uint64
+	PerAddressDaily uint64
+}
+
+// Breach is returned when a payout would go over a limit, nothing covered by the check should be sent
+type Breach struct {
+	Cap       string
+	Limit     uint64
+	Amount    uint64
+	BalanceID uint64
+}
+
+func (b *Breach) Error() string {
+	if b.BalanceID != 0 {
+		return fmt.Sprintf("payout cap %v exceeded for balance %v: %v would be spent against a limit of %v", b.Cap, b.BalanceID, b.Amount, b.Limit)
+	}
+	return fmt.Sprintf("payout cap %v exceeded: %v would be spent against a limit of %v", b.Cap, b.Amount, b.Limit)
+}
+
+// Spend is what has already been handed to the wallet within the Window
+type Spend struct {
+	Total     uint64
+	ByBalance map[uint64]uint64
+}
+
+// GetSpend loads everything handed to the wallet within the Window
+func GetSpend(ctx context.Context, store sql.BatchStore) (Spend, error) {
+	rows, err := store.GetSpendSince(ctx, time.Now().Add(-Window))
+	if err != nil {
+		return Spend{}, err
+	}
+	spend := Spend{ByBalance: make(map[uint64]uint64, len(rows))}
+	for _, row := range rows {
+		spend.Total += row.Amount
+		spend.ByBalance[row.BalanceID] += row.Amount
+	}
+	return spend, nil
+}
+
+// CheckRun checks a whole run before anything is reserved, amounts is what each balance is about to be debited
+func (l Limits) CheckRun(amounts map[uint64]uint64, spend Spend) error {
+	return l.check(PerRun, l.PerRun, amounts, spend)
+}
+
+// CheckBatch checks a single wallet send, amounts is what each of its recipients was debited
+func (l Limits) CheckBatch(amounts map[uint64]uint64, spend Spend) error {
+	return l.check(PerBatch, l.PerBatch, amounts, spend)
+}
+
+func (l Limits) check(name string, limit uint64, amounts map[uint64]uint64, spend Spend) error {
+	var total uint64
+	for balanceID, amount := range amounts {
+		total += amount
+		if l.PerAddressDaily > 0 && spend.ByBalance[balanceID]+amount > l.PerAddressDaily {
+			return &Breach{Cap: PerAddressDaily, Limit: l.PerAddressDaily, Amount: spend.ByBalance[balanceID] + amount, BalanceID: balanceID}
+		}
+	}
+	if limit > 0 && total > limit {
+		return &Breach{Cap: name, Limit: limit, Amount: total}
+	}
+	if l.Daily > 0 && spend.Total+total > l.Daily {
+		return &Breach{Cap: Daily, Limit: l.Daily, Amount: spend.Total + total}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package caps
+
+import (
+	"errors"
+	"testing"
+)
+
+func breachOf(t *testing.T, err error) *Breach {
+	t.Helper()
+	var breach *Breach
+	if !errors.As(err, &breach) {
+		t.Fatalf("got %v, expected a Breach", err)
+	}
+	return breach
+}
+
+func TestCheckOff(t *testing.T) {
+	spend := Spend{Total: 1 << 40, ByBalance: map[uint64]uint64{1: 1 << 40}}
+	if err := (Limits{}).CheckRun(map[uint64]uint64{1: 1 << 40, 2: 1 << 40}, spend); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckPerRunAndBatch(t *testing.T) {
+	limits := Limits{PerRun: 1000, PerBatch: 500}
+	amounts := map[uint64]uint64{1: 300, 2: 400}
+	if err := limits.CheckRun(amounts, Spend{}); err != nil {
+		t.Fatal(err)
+	}
+	breach := breachOf(t, limits.CheckBatch(amounts, Spend{}))
+	if breach.Cap != PerBatch || breach.Limit != 500 || breach.Amount != 700 || breach.BalanceID != 0 {
+		t.Fatalf("got %+v", breach)
+	}
+	// At the limit is fine, only going over it breaches
+	if err := limits.CheckRun(map[uint64]uint64{1: 1000}, Spend{}); err != nil {
+		t.Fatal(err)
+	}
+	breach = breachOf(t, limits.CheckRun(map[uint64]uint64{1: 1001}, Spend{}))
+	if breach.Cap != PerRun {
+		t.Fatalf("got %+v", breach)
+	}
+}
+
+func TestCheckDaily(t *testing.T) {
+	limits := Limits{Daily: 1000, PerAddressDaily: 600}
+	spend := Spend{Total: 800, ByBalance: map[uint64]uint64{1: 500, 2: 300}}
+	breach := breachOf(t, limits.CheckRun(map[uint64]uint64{1: 150}, spend))
+	if breach.Cap != PerAddressDaily || breach.BalanceID != 1 || breach.Amount != 650 || breach.Limit != 600 {
+		t.Fatalf("got %+v", breach)
+	}
+	breach = breachOf(t, limits.CheckBatch(map[uint64]uint64{2: 150, 3: 100}, spend))
+	if breach.Cap != Daily || breach.Amount != 1050 || breach.BalanceID != 0 {
+		t.Fatalf("got %+v", breach)
+	}
+	if err := limits.CheckRun(map[uint64]uint64{2: 100, 3: 100}, spend); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/anomaly"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/caps"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/* payoutDaemon can take its settings from a YAML file given with --config as well as from flags.  Every key is a flag
+	name, plus psql-server, redis-server and sentry-server in place of the environment variables of the same name:
+
+	cron-time: "0,30 * * * *"
+	batch-size: 25
+	cap-daily: 500000000
+	psql-server: postgres://payouts@db/faucet
+
+A flag given on the command line wins over the file, and so does the environment for the three servers.  One-off
+	actions such as --run-once or --approve-payout can't be set from the file.  An unknown key or a value that doesn't
+	parse or validate stops payoutDaemon before it connects to anything.
+
+On SIGHUP the file is read again and the reloadableSettings are applied once any run in progress has finished, a key
+	that has been removed goes back to its default.  Anything else that changed is logged and needs a restart.  A file
+	that fails to load or validate is rejected as a whole and the running settings are kept.
+*/
+
+// configServers maps the config keys that stand in for environment variables to their variable
+var configServers = map[string]string{
+	"psql-server":   "PSQL_SERVER",
+	"redis-server":  "REDIS_SERVER",
+	"sentry-server": "SENTRY_SERVER",
+}
+
+// configActions are flags that do something once and exit, they make no sense in a config file
+var configActions = map[string]bool{
+	"config":         true,
+	"run-once":       true,
+	"set-txn-halt":   true,
+	"unset-txn-halt": true,
+	"resume-batch":   true,
+	"release-batch":  true,
+	"list-approvals": true,
+	"approve-payout": true,
+	"reject-payout":  true,
+	"approval-note":  true,
+}
+
+// reloadableSettings can change between runs without a restart, see applyReloadableSettings
+var reloadableSettings = map[string]bool{
+	"cron-time":                 true,
+	"batch-size":                true,
+	"txn-msg":                   true,
+	"balance-select-order":      true,
+	"cap-per-batch":             true,
+	"cap-per-run":               true,
+	"cap-daily":                 true,
+	"cap-per-address-daily":     true,
+	"approval-threshold":        true,
+	"anomaly-history-multiple":  true,
+	"anomaly-min-history":       true,
+	"anomaly-first-payout-max":  true,
+	"anomaly-jump-max":          true,
+	"anomaly-jump-window":       true,
+	"anomaly-new-address-burst": true,
+	"anomaly-burst-window":      true,
+	"run-timeout":               true,
+}
+
+// readConfig loads a config file as flag name / value pairs, values are left as written for flag.Set to parse
+func readConfig(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]yaml.Node)
+	if err = yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	settings := make(map[string]string, len(nodes))
+	for key, node := range nodes {
+		_, server := configServers[key]
+		if configActions[key] || (!server && flag.Lookup(key) == nil) {
+			return nil, fmt.Errorf("%v: unknown setting %v", path, key)
+		}
+		if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+			return nil, fmt.Errorf("%v: %v must be a single value", path, key)
+		}
+		settings[key] = node.Value
+	}
+	return settings, nil
+}
+
+// applyConfig sets every flag in settings that wasn't given on the command line
+func applyConfig(settings map[string]string, explicit map[string]bool) error {
+	for _, key := range sortedSettings(settings) {
+		if _, server := configServers[key]; server || explicit[key] {
+			continue
+		}
+		if err := flag.Set(key, settings[key]); err != nil {
+			return fmt.Errorf("invalid %v: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// configServer is the environment variable for a server if it is set, then the config file, then the fallback
+func configServer(settings map[string]string, key string, fallback string) string {
+	if value, ok := settings[key]; ok {
+		fallback = value
+	}
+	return getEnv(configServers[key], fallback)
+}
+
+// validateSettings checks the flags, wherever they came from, hold values payoutDaemon can run with
+func validateSettings() error {
+	if _, err := cron.ParseStandard(flagString("cron-time")); err != nil {
+		return fmt.Errorf("invalid cron-time: %w", err)
+	}
+	if flagValue("batch-size").(int) < 1 {
+		return errors.New("batch-size must be at least 1")
+	}
+	if order := flagValue("balance-select-order").(int); order < 0 || order > 2 {
+		return fmt.Errorf("balance-select-order must be 0, 1 or 2, not %v", order)
+	}
+	if len(flagString("txn-msg")) > 256 {
+		return errors.New("txn-msg must be at most 256 characters")
+	}
+	if estimator := flagString("fee-estimator"); estimator != "static" && estimator != "mempool" {
+		return fmt.Errorf("unknown fee-estimator %v", estimator)
+	}
+	if network := flagString("tari-network"); network != "" {
+		if _, err := address.ParseNetwork(network); err != nil {
+			return err
+		}
+	}
+	if flagValue("anomaly-history-multiple").(float64) < 0 {
+		return errors.New("anomaly-history-multiple can't be negative")
+	}
+	for _, name := range []string{"sql-query-timeout", "run-timeout", "wallet-retry-delay", "wallet-retry-max-delay", "wallet-timeout"} {
+		if flagValue(name).(time.Duration) < 0 {
+			return fmt.Errorf("%v can't be negative", name)
+		}
+	}
+	if flagValue("wallet-send-retries").(int) < 0 {
+		return errors.New("wallet-send-retries can't be negative")
+	}
+	if flagValue("anomaly-new-address-burst").(int) < 0 {
+		return errors.New("anomaly-new-address-burst can't be negative")
+	}
+	return nil
+}
+
+// applyReloadableSettings copies the reloadableSettings from their flags to the globals a run reads them from.  It
+// must not be called while a run is in progress, hold runMutex.  cron-time is handled by the caller.
+func applyReloadableSettings() {
+	txnsPerBatch = flagValue("batch-size").(int)
+	txnMsg = flagString("txn-msg")
+	balanceSortOrder = flagValue("balance-select-order").(int)
+	payoutCaps = caps.Limits{
+		PerBatch:        flagValue("cap-per-batch").(uint64),
+		PerRun:          flagValue("cap-per-run").(uint64),
+		Daily:           flagValue("cap-daily").(uint64),
+		PerAddressDaily: flagValue("cap-per-address-daily").(uint64),
+	}
+	approvalThreshold = flagValue("approval-threshold").(uint64)
+	runTimeout = flagValue("run-timeout").(time.Duration)
+	anomalyThresholds = anomaly.Thresholds{
+		HistoryMultiple: flagValue("anomaly-history-multiple").(float64),
+		MinHistory:      flagValue("anomaly-min-history").(uint64),
+		FirstPayoutMax:  flagValue("anomaly-first-payout-max").(uint64),
+		JumpMax:         flagValue("anomaly-jump-max").(uint64),
+		JumpWindow:      flagValue("anomaly-jump-window").(time.Duration),
+		NewAddressBurst: flagValue("anomaly-new-address-burst").(int),
+		BurstWindow:     flagValue("anomaly-burst-window").(time.Duration),
+	}
+}
+
+func flagValue(name string) interface{} {
+	return flag.Lookup(name).Value.(flag.Getter).Get()
+}
+
+func flagString(name string) string {
+	return flag.Lookup(name).Value.String()
+}
+
+func sortedSettings(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configReloader re-reads the config file on SIGHUP and moves the payout cron entry if cron-time changed
+type configReloader struct {
+	milieu   *core.Milieu
+	path     string
+	explicit map[string]bool
+	loaded   map[string]string
+	cron     *cron.Cron
+	entry    cron.EntryID
+	job      func()
+}
+
+// watch reloads the config on every SIGHUP until the process exits
+func (r *configReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			r.milieu.Info(fmt.Sprintf("SIGHUP received, reloading %v", r.path))
+			r.reload()
+		}
+	}()
+}
+
+func (r *configReloader) reload() {
+	settings, err := readConfig(r.path)
+	if err != nil {
+		r.reject(err)
+		return
+	}
+
+	// Settings never change under a run, wait for it to finish
+	if !runMutex.TryLock() {
+		r.milieu.Info("Waiting for the payout run in progress to finish before reloading")
+		runMutex.Lock()
+	}
+	defer runMutex.Unlock()
+
+	previous := make(map[string]string, len(reloadableSettings))
+	for key := range reloadableSettings {
+		previous[key] = flagString(key)
+	}
+	restore := func() {
+		for key, value := range previous {
+			_ = flag.Set(key, value)
+		}
+	}
+	for key := range reloadableSettings {
+		if r.explicit[key] {
+			continue
+		}
+		value, ok := settings[key]
+		if !ok {
+			value = flag.Lookup(key).DefValue
+		}
+		if err = flag.Set(key, value); err != nil {
+			restore()
+			r.reject(fmt.Errorf("invalid %v: %w", key, err))
+			return
+		}
+	}
+	if err = validateSettings(); err != nil {
+		restore()
+		r.reject(err)
+		return
+	}
+
+	if cronTime := flagString("cron-time"); cronTime != previous["cron-time"] {
+		entry, err := r.cron.AddFunc(cronTime, r.job)
+		if err != nil {
+			restore()
+			r.reject(fmt.Errorf("invalid cron-time: %w", err))
+			return
+		}
+		r.cron.Remove(r.entry)
+		r.entry = entry
+	}
+	applyReloadableSettings()
+
+	changed := make([]string, 0)
+	for key := range reloadableSettings {
+		if value := flagString(key); value != previous[key] {
+			changed = append(changed, fmt.Sprintf("%v %q -> %q", key, previous[key], value))
+		}
+	}
+	sort.Strings(changed)
+	for _, key := range r.restartNeeded(settings) {
+		r.milieu.Warn(fmt.Sprintf("%v changed in %v, it only takes effect after a restart", key, r.path))
+	}
+	if len(changed) == 0 {
+		r.milieu.Info("Config reloaded, no reloadable settings changed")
+		return
+	}
+	detail := strings.Join(changed, ", ")
+	r.milieu.Info(fmt.Sprintf("Config reloaded: %v", detail))
+	recordAudit(context.Background(), r.milieu, "payoutDaemon", "reload_config", r.path, detail)
+}
+
+// restartNeeded lists the keys that changed since startup but can't be reloaded
+func (r *configReloader) restartNeeded(settings map[string]string) []string {
+	keys := make([]string, 0)
+	for key, value := range settings {
+		if previous, ok := r.loaded[key]; !reloadableSettings[key] && (!ok || previous != value) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range r.loaded {
+		if _, ok := settings[key]; !reloadableSettings[key] && !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *configReloader) reject(err error) {
+	r.milieu.CaptureException(err)
+	r.milieu.Error(fmt.Sprintf("Config reload rejected, keeping the running settings: %v", err))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/anomaly"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/caps"
+	"github.com/robfig/cron/v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var defineFlagsOnce sync.Once
+
+// setupFlags registers payoutDaemon's flags once per test binary and puts every setting back to its default, along
+// with the globals a reload writes to once the test is done
+func setupFlags(t *testing.T) {
+	t.Helper()
+	defineFlagsOnce.Do(func() { defineFlags() })
+	reset := func() {
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				_ = flag.Set(f.Name, f.DefValue)
+			}
+		})
+	}
+	reset()
+	batchSize, msg, sortOrder, limits, threshold, timeout, thresholds := txnsPerBatch, txnMsg, balanceSortOrder, payoutCaps, approvalThreshold, runTimeout, anomalyThresholds
+	t.Cleanup(func() {
+		reset()
+		txnsPerBatch, txnMsg, balanceSortOrder, payoutCaps, approvalThreshold, runTimeout, anomalyThresholds = batchSize, msg, sortOrder, limits, threshold, timeout, thresholds
+	})
+}
+
+func writeConfig(t *testing.T, path string, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	setupFlags(t)
+	path := filepath.Join(t.TempDir(), "payouts.yaml")
+	writeConfig(t, path, "batch-size: 25\ncron-time: \"0,30 * * * *\"\npsql-server: postgres://payouts@db/faucet\n")
+	settings, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 3 || settings["batch-size"] != "25" || settings["cron-time"] != "0,30 * * * *" || settings["psql-server"] != "postgres://payouts@db/faucet" {
+		t.Fatalf("got %v", settings)
+	}
+
+	// The command line wins, the servers are left to configServer
+	if err = applyConfig(settings, map[string]bool{"cron-time": true}); err != nil {
+		t.Fatal(err)
+	}
+	if flagValue("batch-size").(int) != 25 || flagString("cron-time") != "0 * * * *" {
+		t.Fatalf("got batch-size %v cron-time %v", flagValue("batch-size"), flagString("cron-time"))
+	}
+
+	for _, body := range []string{"no-such-setting: 1\n", "run-once: true\n", "batch-size: [1, 2]\n", "batch-size:\n"} {
+		writeConfig(t, path, body)
+		if _, err = readConfig(path); err == nil {
+			t.Fatalf("%q was accepted", body)
+		}
+	}
+	if err = applyConfig(map[string]string{"batch-size": "lots"}, nil); err == nil {
+		t.Fatal("an unparseable batch-size was accepted")
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	setupFlags(t)
+	milieu, _, _ := setupDaemon(t)
+	path := filepath.Join(t.TempDir(), "payouts.yaml")
+	writeConfig(t, path, "batch-size: 25\ntxn-msg: from the file\nwallet-grpc-address: 127.0.0.1:18143\n")
+	settings, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicit := map[string]bool{"txn-msg": true}
+	if err = flag.Set("txn-msg", "from the command line"); err != nil {
+		t.Fatal(err)
+	}
+	if err = applyConfig(settings, explicit); err != nil {
+		t.Fatal(err)
+	}
+	applyReloadableSettings()
+
+	c := cron.New()
+	job := func() {}
+	entry, err := c.AddFunc(flagString("cron-time"), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &configReloader{milieu: milieu, path: path, explicit: explicit, loaded: settings, cron: c, entry: entry, job: job}
+
+	writeConfig(t, path, "batch-size: 10\ncap-daily: 5000\ncron-time: \"*/5 * * * *\"\ntxn-msg: changed in the file\nwallet-grpc-address: 10.0.0.1:18143\nanomaly-jump-window: 2h\n")
+	r.reload()
+	if txnsPerBatch != 10 || payoutCaps != (caps.Limits{Daily: 5000}) || anomalyThresholds.JumpWindow != 2*time.Hour {
+		t.Fatalf("got batch size %v, caps %+v, anomaly thresholds %+v", txnsPerBatch, payoutCaps, anomalyThresholds)
+	}
+	if txnMsg != "from the command line" {
+		t.Fatalf("got txn-msg %q, the command line should win", txnMsg)
+	}
+	// The payout job is moved to the new schedule, not added alongside the old one
+	if r.entry == entry || c.Entry(entry).Valid() || !c.Entry(r.entry).Valid() || len(c.Entries()) != 1 {
+		t.Fatalf("cron entries after reload: %+v", c.Entries())
+	}
+	// Not reloadable, it is logged and left for a restart
+	if restart := r.restartNeeded(map[string]string{"wallet-grpc-address": "10.0.0.1:18143"}); len(restart) != 1 || restart[0] != "wallet-grpc-address" {
+		t.Fatalf("got %v", restart)
+	}
+
+	// A file that fails to parse or validate is rejected as a whole
+	for _, body := range []string{"batch-size: 20\ncap-daily: lots\n", "batch-size: 0\ncap-daily: 1\n", "batch-size: 20\ncron-time: never\n"} {
+		writeConfig(t, path, body)
+		r.reload()
+		if txnsPerBatch != 10 || payoutCaps.Daily != 5000 || flagString("cron-time") != "*/5 * * * *" {
+			t.Fatalf("%q: got batch size %v, caps %+v, cron-time %v", body, txnsPerBatch, payoutCaps, flagString("cron-time"))
+		}
+	}
+
+	// Removed from the file, back to the default
+	writeConfig(t, path, "cap-daily: 5000\n")
+	r.reload()
+	if txnsPerBatch != 50 || anomalyThresholds != (anomaly.Thresholds{MinHistory: 3, JumpWindow: 24 * time.Hour, BurstWindow: time.Hour}) {
+		t.Fatalf("got batch size %v, anomaly thresholds %+v", txnsPerBatch, anomalyThresholds)
+	}
+}
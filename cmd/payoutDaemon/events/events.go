@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"time"
+)
+
+// Payout lifecycle events, published as JSON on a redis pub/sub channel for anything that wants to react to them
+// (notifications, dashboards, the auto-repay), and logged so there is a record even with nobody subscribed.
+
+// DefaultChannel is the redis channel events are published on unless told otherwise
+const DefaultChannel = "payout-events"
+
+const (
+	// Mined is sent the first time a payout is seen in a block, or when a reorg moves it to another height
+	Mined = "mined"
+	// Confirmed is sent once a payout is buried under the required number of blocks, it is never polled again
+	Confirmed = "confirmed"
+	// Dropped is sent when the wallet reports a payout as cancelled or rejected, the coins never arrived
+	Dropped = "dropped"
+	// CapBreached is sent when payoutDaemon halts itself because a run would go over a payout cap, it needs a human
+	CapBreached = "cap_breached"
+	// ApprovalRequested is sent when a payout over the approval threshold is put in front of an operator
+	ApprovalRequested = "approval_requested"
+)
+
+type Event struct {
+	Type          string    `json:"type"`
+	TxID          uint64    `json:"tx_id"`
+	Status        uint64    `json:"status"`
+	Amount        uint64    `json:"amount"`
+	MinedAtHeight uint64    `json:"mined_at_height,omitempty"`
+	Confirmations uint64    `json:"confirmations,omitempty"`
+	DestAddress   []byte    `json:"dest_address,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// Publish logs the event and pushes it to the channel, a failed publish is reported but never stops the caller
+func Publish(milieu *core.Milieu, channel string, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return
+	}
+	milieu.Info(fmt.Sprintf("Event %v: %s", event.Type, body))
+	if err = milieu.GetRedis().Publish(context.Background(), channel, body).Err(); err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+	}
+}
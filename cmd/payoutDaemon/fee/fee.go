@@ -0,0 +1,104 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+/* fee works out what a payout is going to cost before it's handed to the wallet.
+
+The wallet splits a multi-recipient transfer into one transaction per recipient, so the fee for a recipient is the
+	fee-per-gram multiplied by the weight of a single payout transaction, and the fee for a batch is that times the
+	number of recipients.  The fee-per-gram comes from an Estimator, either a fixed value, or one scaled by how busy the
+	base node mempool is.
+*/
+
+var ErrNoFeePerGram = errors.New("fee estimator returned a fee-per-gram of 0")
+
+// Estimator provides the fee-per-gram to use for the next batch
+type Estimator interface {
+	FeePerGram(ctx context.Context) (uint64, error)
+}
+
+// StaticEstimator always returns the same fee-per-gram
+type StaticEstimator struct {
+	PerGram uint64
+}
+
+func (s *StaticEstimator) FeePerGram(ctx context.Context) (uint64, error) {
+	return s.PerGram, nil
+}
+
+// MempoolEstimator starts at Base and adds a gram of fee for every StepWeight grams waiting in the base node mempool,
+// never going above Max
+type MempoolEstimator struct {
+	BaseNodeAddress string
+	Base            uint64
+	Max             uint64
+	StepWeight      uint64
+}
+
+func (m *MempoolEstimator) FeePerGram(ctx context.Context) (uint64, error) {
+	conn, err := grpc.NewClient(m.BaseNodeAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stats, err := tari_generated.NewBaseNodeClient(conn).GetMempoolStats(ctx, &tari_generated.Empty{})
+	if err != nil {
+		return 0, err
+	}
+	perGram := m.Base
+	if m.StepWeight > 0 {
+		perGram += stats.UnconfirmedWeight / m.StepWeight
+	}
+	if m.Max > 0 && perGram > m.Max {
+		perGram = m.Max
+	}
+	return perGram, nil
+}
+
+// Policy turns an Estimator into a Quote for a batch
+type Policy struct {
+	Estimator Estimator
+	// TxWeight is the weight in grams of a single payout transaction
+	TxWeight uint64
+}
+
+// Quote is the fee for a batch, fixed at the time it was asked for
+type Quote struct {
+	FeePerGram      uint64
+	FeePerRecipient uint64
+}
+
+// Quote asks the estimator for the current fee-per-gram and works out the fee for a single recipient
+func (p *Policy) Quote(ctx context.Context) (Quote, error) {
+	perGram, err := p.Estimator.FeePerGram(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+	if perGram == 0 {
+		return Quote{}, ErrNoFeePerGram
+	}
+	perRecipient := perGram * p.TxWeight
+	return Quote{
+		FeePerGram:      perGram,
+		FeePerRecipient: perRecipient,
+	}, nil
+}
+
+// BatchFee is the fee for a batch with the given number of recipients
+func (q Quote) BatchFee(recipients int) uint64 {
+	return q.FeePerRecipient * uint64(recipients)
+}
+
+// SendAmount returns what is left of the balance once the fee is taken out, false if the balance can't cover the fee
+func (q Quote) SendAmount(balance uint64) (uint64, bool) {
+	if balance <= q.FeePerRecipient {
+		return 0, false
+	}
+	return balance - q.FeePerRecipient, true
+}
@@ -0,0 +1,43 @@
+package fee
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type failingEstimator struct{}
+
+func (failingEstimator) FeePerGram(ctx context.Context) (uint64, error) {
+	return 0, errors.New("base node unreachable")
+}
+
+func TestQuote(t *testing.T) {
+	policy := &Policy{Estimator: &StaticEstimator{PerGram: 5}, TxWeight: 1000}
+	quote, err := policy.Quote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.FeePerGram != 5 || quote.FeePerRecipient != 5000 {
+		t.Fatalf("got %+v", quote)
+	}
+	if quote.BatchFee(3) != 15000 {
+		t.Fatalf("got %v", quote.BatchFee(3))
+	}
+	if amount, ok := quote.SendAmount(12000); !ok || amount != 7000 {
+		t.Fatalf("got %v %v", amount, ok)
+	}
+	// A balance that only covers the fee has nothing left to send
+	if amount, ok := quote.SendAmount(5000); ok || amount != 0 {
+		t.Fatalf("got %v %v", amount, ok)
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	if _, err := (&Policy{Estimator: &StaticEstimator{}, TxWeight: 1000}).Quote(context.Background()); !errors.Is(err, ErrNoFeePerGram) {
+		t.Fatalf("got %v, expected %v", err, ErrNoFeePerGram)
+	}
+	if _, err := (&Policy{Estimator: failingEstimator{}, TxWeight: 1000}).Quote(context.Background()); err == nil {
+		t.Fatal("estimator error was dropped")
+	}
+}
@@ -0,0 +1,114 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sync"
+)
+
+/* leader elects a single payoutDaemon out of any number running against the same database.
+
+Leadership is a Postgres session level advisory lock held on a dedicated connection taken out of the pool.  Postgres
+	drops the lock the moment that session ends, so a crashed, partitioned or killed leader can't keep it, and a standby
+	picks it up on its next TryAcquire.  The flip side is that a leader can lose the lock without noticing, so anything
+	about to do something irreversible (hand a payment to the wallet) must call Check first and stop if it fails.
+*/
+
+var ErrNotLeader = errors.New("leader lock is not held")
+var ErrLockLost = errors.New("leader lock was lost")
+
+// Elector is what payoutDaemon needs from a Lock, so it can be run without Postgres in tests
+type Elector interface {
+	TryAcquire() (bool, error)
+	Check() error
+}
+
+type Lock struct {
+	pool *pgxpool.Pool
+	key  int64
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+func New(pool *pgxpool.Pool, key int64) *Lock {
+	return &Lock{pool: pool, key: key}
+}
+
+// TryAcquire takes the lock if it is free, returning whether this instance is the leader.  It is safe to call while
+// already holding the lock, in which case the lock is checked instead.
+func (l *Lock) TryAcquire() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		if err := l.checkLocked(); err != nil {
+			l.releaseLocked()
+			// Lost it, fall through and try to take it again on a fresh connection
+		} else {
+			return true, nil
+		}
+	}
+	conn, err := l.pool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	if err = conn.QueryRow(context.Background(), "select pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, err
+	}
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Check confirms the lock is still held, it must pass before anything is sent
+func (l *Lock) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return ErrNotLeader
+	}
+	if err := l.checkLocked(); err != nil {
+		l.releaseLocked()
+		return err
+	}
+	return nil
+}
+
+func (l *Lock) checkLocked() error {
+	// Look the lock up in pg_locks for our own backend rather than just pinging, a pooler in between could have handed
+	// us a different session.  Bigint advisory keys are split across classid (high) and objid (low) with objsubid 1.
+	var held bool
+	err := l.conn.QueryRow(context.Background(), "select exists(select 1 from pg_locks where locktype = 'advisory' and granted and pid = pg_backend_pid() and objsubid = 1 and ((classid::bigint << 32) | objid::bigint) = $1)", l.key).Scan(&held)
+	if err != nil {
+		return errors.Join(ErrLockLost, err)
+	}
+	if !held {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release gives up leadership, if it is held
+func (l *Lock) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *Lock) releaseLocked() {
+	if l.conn == nil {
+		return
+	}
+	_, err := l.conn.Exec(context.Background(), "select pg_advisory_unlock($1)", l.key)
+	if err != nil {
+		// The session is in an unknown state, close it outright so Postgres drops the lock with it
+		_ = l.conn.Conn().Close(context.Background())
+	}
+	l.conn.Release()
+	l.conn = nil
+}
@@ -2,43 +2,114 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/anomaly"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/caps"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/events"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/fee"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/leader"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/metrics"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/paymentid"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/report"
 	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
 	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
-	"github.com/Snipa22/go-tari-grpc-lib/v2/walletGRPC"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
+	"io"
 	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
-/* payoutDaemon does the following steps, on a cron schedule set by a flag, or on the hour by default:
+/* payoutDaemon does the following steps, on a cron schedule set by a flag or the config file, see config.go, or on the hour by default:
 
 Scans the `balances` postgresql table to build a list of valid payouts - this uses redis to check the full balance list
 	for a bypass for an address in case it should be paid out still, using the key `bal_bypass_<address` with a val of 1
-This gets compiled into transaction objects, which is submitted to `walletGRPCAddress`
+This gets compiled into transaction objects, and in a single PSQL txn every balance is debited and queued as a
+	recipient in `payment_batch_recipients`, nothing is sent if this fails.
+The transaction objects are then flagged as submitted and handed to `walletGRPCAddress`
 	We'll go into a PSQL txn state at this time, then do the following:
 	1. Add the transfer to `transfers`
-	2-fail. Then we'll commit the txn and continue
-	2-success. Subtract the balance of the transfer from `balances`
-	3. Unset the redis key.
-	4. Add data to the `payments` struct so we can log it to the `payment_batch` table
-	5. Commit the txn.
+	2-fail. Credit the reserved amount back to `balances`
+	2-success. Nothing to do, the balance was debited by the reservation
+	3. Mark the recipient as succeeded or failed
+	4. Commit the txn.
+	5. Unset the redis key.
+Any recipient left as submitted by a crash between the wallet send and the commit above is reconciled against the wallet
+	on startup and before every run, see recovery.go, and payouts the wallet later drops are credited back, see
+	repayment.go.  Recipients left queued by a halt or a shutdown can be resumed, see resume.go, SIGTERM and SIGINT
+	always let the send in progress and its bookkeeping finish first, see shutdown.go.
 Once the above is processed for every TXN, we'll go into the payments struct and commit it to the `payments` table, then
 	sleep until the next cron pass
 
+Any number of payoutDaemons can run against the same database, only the one holding the leader lock does any of the
+	above, the rest stand by and try to take it each tick.  The lock is checked before every wallet send, a leader that
+	loses it stops sending and leaves the rest of the batch queued, see the leader package.
+
 payoutDaemon is /not/ designed to perform any additional GRPC calls/etc, it is /very/ light and dedicated exclusively to
 	transactions.  Check grpcWalletData for a more generic set of interfaces
 */
 
 var isDryRun bool
 var txnMsg string
-var running = false
+var runMutex sync.Mutex
 var txnsPerBatch = 50
 var haltTxnKey = "payout-daemon-halt-batching"
 var balanceSortOrder = 0
+var feePolicy *fee.Policy
+var walletClient wallet.Client
+var store sql.Store
+var leaderLock leader.Elector
+var autoRepay = true
+var payoutCaps caps.Limits
+var anomalyThresholds anomaly.Thresholds
+var eventChannel = events.DefaultChannel
+var dryRunReportPath = "dry-run-report"
+var runTimeout = 50 * time.Minute
+
+// tariNetwork is the network every payout address must be for, nil only checks the address is well formed
+var tariNetwork *address.Network
+
+// parsePayoutAddress checks a balance address can be paid before it goes anywhere near the wallet
+func parsePayoutAddress(addr string) (*address.Address, error) {
+	if tariNetwork == nil {
+		return address.Parse(addr)
+	}
+	return address.Validate(addr, *tariNetwork)
+}
+
+// bypassKey is the redis key that lets a balance be paid under its payout minimum.  It is keyed on the canonical address
+// so faucetServer, the admin API and payoutDaemon agree on it, whatever form the balance was stored in.
+func bypassKey(addr string) string {
+	if canonical, err := address.Canonical(addr); err == nil {
+		addr = canonical
+	}
+	return fmt.Sprintf("bal_bypass_%v", addr)
+}
+
+// isLeader makes sure this instance holds the leader lock, nothing that touches balances or the wallet runs without it
+func isLeader(milieu *core.Milieu) bool {
+	ok, err := leaderLock.TryAcquire()
+	metrics.SetBool(metrics.Leader, ok)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return false
+	}
+	if !ok {
+		milieu.Info("Another payoutDaemon holds the leader lock, standing by")
+	}
+	return ok
+}
 
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
@@ -47,7 +118,98 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func atomicBalanceUpdates(milieu *core.Milieu, daemonResponse *tari_generated.TransferResponse, addressCache map[string]uint64, balanceCache map[string]uint64, batchID int) (successAmount uint64, failedAmount uint64, err error) {
+// recordAudit records a change made outside the admin API, by a command line flag or by payoutDaemon itself
+func recordAudit(ctx context.Context, milieu *core.Milieu, actor string, action string, subject string, detail string) {
+	err := store.CreateAuditEntry(ctx, sql.AuditEntry{
+		Actor:   actor,
+		Action:  action,
+		Subject: subject,
+		Detail:  detail,
+	})
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+	}
+}
+
+// haltForBreach sets the halt key so nothing more is sent until someone has looked at why a cap was hit, and raises
+// the alarm on every channel we have
+func haltForBreach(ctx context.Context, milieu *core.Milieu, breach *caps.Breach) {
+	milieu.GetRedis().Set(context.Background(), haltTxnKey, 1, 0)
+	metrics.Halted.Set(1)
+	metrics.CapBreachesTotal.WithLabelValues(breach.Cap).Inc()
+	milieu.CaptureException(breach)
+	milieu.Error(fmt.Sprintf("%v, payouts halted, unset the halt once it has been checked", breach))
+	events.Publish(milieu, eventChannel, events.Event{Type: events.CapBreached, Amount: breach.Amount, Reason: breach.Error()})
+	recordAudit(ctx, milieu, "payoutDaemon", "set_halt", haltTxnKey, breach.Error())
+}
+
+// checkCap runs a cap check, halting payouts if it was breached, any error means nothing covered by it should be sent
+func checkCap(ctx context.Context, milieu *core.Milieu, check func(map[uint64]uint64, caps.Spend) error, addresses []string, addressCache map[string]uint64, balanceCache map[string]uint64) error {
+	spend, err := caps.GetSpend(ctx, store)
+	if err != nil {
+		return err
+	}
+	amounts := make(map[uint64]uint64, len(addresses))
+	for _, addr := range addresses {
+		amounts[addressCache[addr]] += balanceCache[addr]
+	}
+	err = check(amounts, spend)
+	var breach *caps.Breach
+	if errors.As(err, &breach) && !isDryRun {
+		haltForBreach(ctx, milieu, breach)
+	}
+	return err
+}
+
+// writeDryRunReport compares the report with the last real batch and writes it out as JSON and CSV
+func writeDryRunReport(ctx context.Context, milieu *core.Milieu, runReport *report.Report) {
+	batch, err := store.GetLatestBatch(ctx)
+	if err == nil {
+		recipients, err := store.GetAllBatchRecipients(ctx, batch.ID)
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		} else {
+			runReport.Compare(batch, recipients)
+		}
+	} else if !errors.Is(err, sql.ErrNotFound) {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+	}
+
+	writers := []struct {
+		extension string
+		write     func(io.Writer) error
+	}{{".json", runReport.WriteJSON}, {".csv", runReport.WriteCSV}}
+	for _, writer := range writers {
+		path := dryRunReportPath + writer.extension
+		file, err := os.Create(path)
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+			continue
+		}
+		err = writer.write(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+			continue
+		}
+		milieu.Info(fmt.Sprintf("Dry run report written to %v", path))
+	}
+	milieu.Info(fmt.Sprintf("Dry run: %v recipients for %v (%v in fees), %v of %v balances skipped",
+		runReport.Totals.Recipients, runReport.Totals.Amount, runReport.Totals.Fees, runReport.Totals.Skipped, runReport.Totals.Balances))
+}
+
+func atomicBalanceUpdates(ctx context.Context, milieu *core.Milieu, daemonResponse *tari_generated.TransferResponse, addressCache map[string]uint64, balanceCache map[string]uint64, batchID int) (successAmount uint64, failedAmount uint64, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.BalanceUpdateDuration.Observe(time.Since(start).Seconds())
+	}()
 	for _, v := range daemonResponse.GetResults() {
 		// Each result needs to be handled cleanly
 		milieu.Debug(fmt.Sprintf("Processing transaction: %v for %v", v.TransactionId, addressCache[v.Address]))
@@ -56,10 +218,11 @@ func atomicBalanceUpdates(milieu *core.Milieu, daemonResponse *tari_generated.Tr
 		} else {
 			failedAmount += balanceCache[v.Address]
 		}
-		txn, err := milieu.GetTransaction()
+		txn, err := store.Begin(ctx)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
+			metrics.BalanceUpdateErrors.Inc()
 			continue
 		}
 		if v.TransactionId == 0 {
@@ -67,68 +230,252 @@ func atomicBalanceUpdates(milieu *core.Milieu, daemonResponse *tari_generated.Tr
 			// given how big the uint64 size is.
 			v.TransactionId = rand.Uint64()
 		}
-		txn.Begin(context.Background())
-		defer milieu.CleanupTxn()
-		err = sql.CreateNewTransaction(txn, v.TransactionId, v.IsSuccess, v.FailureMessage, addressCache[v.Address], batchID, balanceCache[v.Address])
+		// The fee is filled in from the wallet's view of the transaction once we have it, see sendPayments
+		err = store.CreateNewTransaction(ctx, txn, v.TransactionId, v.IsSuccess, v.FailureMessage, addressCache[v.Address], batchID, balanceCache[v.Address], 0)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
-			milieu.CleanupTxn()
+			metrics.BalanceUpdateErrors.Inc()
+			_ = txn.Rollback()
 			continue
 		}
+		state := sql.RecipientSucceeded
 		if !v.IsSuccess {
-			txn.Commit(context.Background())
-			milieu.CleanupTxn()
-			continue
+			state = sql.RecipientFailed
 		}
-		err = sql.DecreaseBalance(txn, addressCache[v.Address], balanceCache[v.Address])
+		// Fails if a release or recovery got to the recipient first, it is theirs to settle
+		err = store.ResolveBatchRecipient(ctx, txn, batchID, addressCache[v.Address], sql.RecipientSubmitted, state, v.TransactionId, v.FailureMessage)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
-			milieu.CleanupTxn()
+			metrics.BalanceUpdateErrors.Inc()
+			_ = txn.Rollback()
 			continue
 		}
-		txn.Commit(context.Background())
-		milieu.CleanupTxn()
+		if !v.IsSuccess {
+			// The balance was debited when the payout was reserved, hand it back.
+			err = store.IncreaseBalance(ctx, txn, addressCache[v.Address], balanceCache[v.Address], sql.LedgerRepay, fmt.Sprintf("tx:%v", v.TransactionId))
+			if err != nil {
+				milieu.CaptureException(err)
+				milieu.Info(err.Error())
+				metrics.BalanceUpdateErrors.Inc()
+				_ = txn.Rollback()
+				continue
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+			metrics.BalanceUpdateErrors.Inc()
+			continue
+		}
+		if !v.IsSuccess {
+			metrics.RecipientsTotal.WithLabelValues(sql.RecipientFailed).Inc()
+			metrics.AmountTotal.WithLabelValues(sql.RecipientFailed).Add(float64(balanceCache[v.Address]))
+			continue
+		}
+		metrics.RecipientsTotal.WithLabelValues(sql.RecipientSucceeded).Inc()
+		metrics.AmountTotal.WithLabelValues(sql.RecipientSucceeded).Add(float64(balanceCache[v.Address]))
 		client := milieu.GetRedis()
-		client.Del(context.Background(), fmt.Sprintf("bal_bypass_%v", v.Address))
+		client.Del(context.Background(), bypassKey(v.Address))
 	}
 	return
 }
 
-func performPayouts(milieu *core.Milieu) {
-	if running {
+// reservePayouts debits every balance in the payment list and queues a matching `payment_batch_recipients` row in a
+// single PSQL txn, this has to succeed before anything is handed to the wallet.  Any approval a payment needed is
+// consumed in the same txn.
+func reservePayouts(ctx context.Context, milieu *core.Milieu, payments []*tari_generated.PaymentRecipient, addressCache map[string]uint64, balanceCache map[string]uint64, approvalCache map[string]uint64, batchID int) error {
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	reference := fmt.Sprintf("batch:%v", batchID)
+	for _, payment := range payments {
+		// The send and the fee are separate ledger entries, together they are the whole reservation
+		if err = store.DecreaseBalance(ctx, txn, addressCache[payment.Address], payment.Amount, sql.LedgerPayoutDebit, reference); err != nil {
+			return err
+		}
+		if feeAmount := balanceCache[payment.Address] - payment.Amount; feeAmount > 0 {
+			if err = store.DecreaseBalance(ctx, txn, addressCache[payment.Address], feeAmount, sql.LedgerFee, reference); err != nil {
+				return err
+			}
+		}
+		if err = store.CreateBatchRecipient(ctx, txn, batchID, addressCache[payment.Address], payment.Address, balanceCache[payment.Address], payment.Amount, payment.FeePerGram); err != nil {
+			return err
+		}
+		if approvalID, ok := approvalCache[payment.Address]; ok {
+			if err = store.ConsumeApproval(ctx, txn, approvalID, batchID); err != nil {
+				return err
+			}
+		}
+	}
+	return txn.Commit()
+}
+
+// newPaymentRecipient builds the wallet side of a payout, its payment ID is set once it has a batch, see submitPayments
+func newPaymentRecipient(address string, amount uint64, feePerGram uint64) *tari_generated.PaymentRecipient {
+	return &tari_generated.PaymentRecipient{
+		Address:       address,
+		Amount:        amount,
+		FeePerGram:    feePerGram,
+		PaymentType:   1,
+		UserPaymentId: nil,
+	}
+}
+
+// submitPayments flags the recipients as submitted and hands them to the wallet, once flagged a wallet error leaves the
+// reservations in place, we can't know if the wallet sent the transactions or not, recoverPendingPayouts will sort it
+// out on the next run.  Sends that failed on the way are retried, see sendWithRetry, cancelling ctx only stops the retries.
+func submitPayments(ctx context.Context, milieu *core.Milieu, batchID int, payments []*tari_generated.PaymentRecipient, addressCache map[string]uint64, balanceCache map[string]uint64) (*tari_generated.TransferResponse, error) {
+	bookkeeping := context.WithoutCancel(ctx)
+	balanceIDs := make([]uint64, 0, len(payments))
+	addresses := make([]string, 0, len(payments))
+	for _, payment := range payments {
+		balanceIDs = append(balanceIDs, addressCache[payment.Address])
+		addresses = append(addresses, payment.Address)
+		// Tags the transaction so the wallet can tell us which recipient it paid, see paymentid
+		payment.UserPaymentId = &tari_generated.UserPaymentId{
+			Utf8String: paymentid.Format(paymentid.ID{BatchID: batchID, BalanceID: addressCache[payment.Address]}, txnMsg),
+		}
+	}
+	// Still queued at this point, a breach leaves them to be resumed or released
+	if err := checkCap(bookkeeping, milieu, payoutCaps.CheckBatch, addresses, addressCache, balanceCache); err != nil {
+		return nil, err
+	}
+	updated, err := store.SetBatchRecipientsSubmitted(bookkeeping, batchID, balanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	if updated != int64(len(balanceIDs)) {
+		// Someone else moved some of these on, sending now could pay them twice.  Anything we did flag is settled by
+		// recovery like any other submitted recipient.
+		return nil, fmt.Errorf("batch %v: only %v of %v recipients were still queued, not sending", batchID, updated, len(balanceIDs))
+	}
+	return sendWithRetry(ctx, milieu, batchID, payments, addressCache)
+}
+
+// performPayouts is a single payout run, cancelling ctx stops it before the next batch or wallet send
+func performPayouts(ctx context.Context, milieu *core.Milieu) {
+	// A slow run can still be going when the next cron tick fires
+	if !runMutex.TryLock() {
 		return
 	}
-	running = true
+	defer runMutex.Unlock()
+	start := time.Now()
+	result := metrics.RunError
 	defer func() {
-		running = false
+		metrics.RunsTotal.WithLabelValues(result).Inc()
+		metrics.RunDuration.Observe(time.Since(start).Seconds())
+		metrics.LastRunTimestamp.SetToCurrentTime()
 	}()
+	if ctx.Err() != nil {
+		result = metrics.RunInterrupted
+		return
+	}
+
+	// A stuck database or wallet fails the run once the deadline is up, rather than holding it forever
+	var cancel context.CancelFunc
+	if runTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	defer func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err := fmt.Errorf("payout run took longer than its deadline of %v, anything not yet sent has been left", runTimeout)
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+			result = metrics.RunTimedOut
+		}
+	}()
+	if !isDryRun && !isLeader(milieu) {
+		result = metrics.RunNotLeader
+		return
+	}
+	session.runs += 1
 	blocked := milieu.GetRedis().Exists(context.Background(), haltTxnKey)
+	metrics.SetBool(metrics.Halted, blocked.Val() != 0)
 	if blocked.Val() != 0 {
 		// We're blocked by the halt txn key in redis, report and return.
 		milieu.Info("Payout system halted due to redis key set, check with your local admin!")
+		result = metrics.RunHalted
 		return
 	}
 
+	if !isDryRun {
+		// Anything left submitted has to be settled before we look at balances again.
+		if err := recoverPendingPayouts(ctx, milieu); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		}
+		if autoRepay {
+			if err := repayDroppedPayouts(ctx, milieu); err != nil {
+				milieu.CaptureException(err)
+				milieu.Info(err.Error())
+			}
+		}
+	}
+
 	milieu.Info("Starting payouts")
 
 	milieu.Debug("Starting balance fetch")
-	balances, err := sql.GetAllBalances(milieu, balanceSortOrder)
+	balances, err := store.GetAllBalances(ctx, balanceSortOrder)
 	if err != nil {
 		milieu.Info(err.Error())
 		milieu.CaptureException(err)
 		return
 	}
+	metrics.BalancesScanned.Set(float64(len(balances)))
 	if len(balances) == 0 {
 		milieu.Info("No balances found, exiting run")
+		result = metrics.RunNoPayments
 		return
 	}
 	milieu.Info(fmt.Sprintf("%v balances found", len(balances)))
 
+	// Fix the fee for the whole run up front, if we can't work out what it costs we don't send anything
+	quote, err := feePolicy.Quote(ctx)
+	if err != nil {
+		milieu.Info(err.Error())
+		milieu.CaptureException(err)
+		return
+	}
+	milieu.Info(fmt.Sprintf("Using a fee of %v per gram, %v per recipient", quote.FeePerGram, quote.FeePerRecipient))
+	metrics.FeePerGram.Set(float64(quote.FeePerGram))
+
+	checker, err := anomaly.NewChecker(ctx, store, anomalyThresholds, balances)
+	if err != nil {
+		milieu.Info(err.Error())
+		milieu.CaptureException(err)
+		return
+	}
+	openApprovals := make(map[uint64]sql.PayoutApproval)
+	if approvalThreshold > 0 {
+		approvals, err := store.GetOpenApprovals(ctx)
+		if err != nil {
+			milieu.Info(err.Error())
+			milieu.CaptureException(err)
+			return
+		}
+		for _, approval := range approvals {
+			openApprovals[approval.BalanceID] = approval
+		}
+	}
+
 	// Cache the address -> ID map for later use, as well as the address -> amount map
 	addressCache := make(map[string]uint64)
 	balanceCache := make(map[string]uint64)
+	approvalCache := make(map[string]uint64)
+
+	// Everything the run decides goes in the report, only written out on a dry run
+	runReport := report.New(quote.FeePerGram, len(balances))
+	skip := func(balance sql.BalanceSqlRow, reason string, detail string) {
+		metrics.BalancesSkipped.WithLabelValues(reason).Inc()
+		runReport.AddSkipped(report.Skipped{BalanceID: balance.ID, Address: balance.Address, Balance: balance.Balance, Reason: reason, Detail: detail})
+	}
 
 	// With balances found, lets start the real processing
 	payments := make([]*tari_generated.PaymentRecipient, 0)
@@ -138,57 +485,153 @@ func performPayouts(milieu *core.Milieu) {
 		if !sqlBalance.Valid {
 			// Balance is tagged as invalid, do not process
 			milieu.Debug(fmt.Sprintf("%v is set to invalid", sqlBalance.ID))
+			skip(sqlBalance, "invalid", sqlBalance.InvalidReason)
+			continue
+		}
+		if _, err = parsePayoutAddress(sqlBalance.Address); err != nil {
+			// The wallet would only reject it mid-batch, take it out of the payout rotation until someone looks at it
+			milieu.Warn(fmt.Sprintf("Balance %v has a bad address %q: %v", sqlBalance.ID, sqlBalance.Address, err))
+			skip(sqlBalance, "bad_address", err.Error())
+			// The wallet is the authority on emoji ids, if our copy of the alphabet falls behind Tari's we'd otherwise
+			// invalidate every emoji balance.  Leave those valid, they are only skipped until someone looks at them.
+			if !isDryRun && !address.IsEmoji(sqlBalance.Address) {
+				if err = store.MarkBalanceInvalid(ctx, sqlBalance.ID, err.Error()); err != nil {
+					milieu.CaptureException(err)
+					milieu.Info(err.Error())
+				}
+			}
 			continue
 		}
+		inclusion := report.MinimumMet
 		if sqlBalance.Balance < sqlBalance.PayoutMinimum {
 			// Check to see if there's a bypass in redis
-			val := milieu.GetRedis().Exists(context.Background(), fmt.Sprintf("bal_bypass_%v", sqlBalance.Address))
+			val := milieu.GetRedis().Exists(context.Background(), bypassKey(sqlBalance.Address))
 			if val.Val() == 0 {
 				milieu.Debug(fmt.Sprintf("Balance for %v does not get a bypass and is under payout minimum, "+
 					"skipping", sqlBalance.ID))
+				skip(sqlBalance, "under_minimum", fmt.Sprintf("payout minimum is %v", sqlBalance.PayoutMinimum))
 				continue
 			}
+			inclusion = report.Bypass
 		}
-		milieu.Debug(fmt.Sprintf("Adding %v to payment ready for %v", sqlBalance.ID, sqlBalance.Balance))
-		totalAmount += sqlBalance.Balance
-		paymentRecipient := &tari_generated.PaymentRecipient{
-			Address:       sqlBalance.Address,
-			Amount:        sqlBalance.Balance - 5000,
-			FeePerGram:    5,
-			PaymentType:   1,
-			UserPaymentId: nil,
-		}
-		if len(txnMsg) > 0 {
-			paymentRecipient.UserPaymentId = &tari_generated.UserPaymentId{
-				Utf8String: txnMsg,
+		sendAmount, ok := quote.SendAmount(sqlBalance.Balance)
+		if !ok {
+			milieu.Debug(fmt.Sprintf("Balance for %v can't cover the fee of %v, skipping", sqlBalance.ID, quote.FeePerRecipient))
+			skip(sqlBalance, "under_fee", fmt.Sprintf("fee is %v", quote.FeePerRecipient))
+			continue
+		}
+		if checker.InReview(sqlBalance) {
+			milieu.Debug(fmt.Sprintf("Balance for %v is waiting on a review, skipping", sqlBalance.ID))
+			skip(sqlBalance, "in_review", "")
+			continue
+		}
+		if reasons := checker.Check(sqlBalance); len(reasons) > 0 {
+			// Don't pay it, put it in front of a human
+			milieu.Warn(fmt.Sprintf("Balance %v of %v looks wrong, quarantining for review: %v", sqlBalance.ID, sqlBalance.Balance, anomaly.Reasons(reasons)))
+			skip(sqlBalance, "quarantined", anomaly.Reasons(reasons))
+			if !isDryRun {
+				if _, err = store.CreateReview(ctx, sqlBalance.ID, sqlBalance.Address, sqlBalance.Balance, anomaly.Reasons(reasons)); err != nil {
+					milieu.CaptureException(err)
+					milieu.Info(err.Error())
+				}
 			}
+			continue
 		}
-		payments = append(payments, paymentRecipient)
+		if approvalThreshold > 0 && sqlBalance.Balance > approvalThreshold {
+			approvalID, ok := approvalFor(ctx, milieu, sqlBalance, openApprovals)
+			if !ok {
+				milieu.Info(fmt.Sprintf("Balance %v of %v is over the approval threshold, waiting on approval", sqlBalance.ID, sqlBalance.Balance))
+				skip(sqlBalance, "awaiting_approval", fmt.Sprintf("approval threshold is %v", approvalThreshold))
+				continue
+			}
+			approvalCache[sqlBalance.Address] = approvalID
+		}
+		milieu.Debug(fmt.Sprintf("Adding %v to payment ready for %v", sqlBalance.ID, sqlBalance.Balance))
+		runReport.AddRecipient(report.Recipient{
+			BalanceID:  sqlBalance.ID,
+			Address:    sqlBalance.Address,
+			Amount:     sqlBalance.Balance,
+			SendAmount: sendAmount,
+			Fee:        sqlBalance.Balance - sendAmount,
+			Reason:     inclusion,
+			ApprovalID: approvalCache[sqlBalance.Address],
+		})
+		totalAmount += sqlBalance.Balance
+		payments = append(payments, newPaymentRecipient(sqlBalance.Address, sendAmount, quote.FeePerGram))
 		addressCache[sqlBalance.Address] = sqlBalance.ID
 		balanceCache[sqlBalance.Address] = sqlBalance.Balance
 	}
+	metrics.PaymentsPrepared.Set(float64(len(payments)))
+	metrics.AmountPrepared.Set(float64(totalAmount))
 	if len(payments) == 0 {
 		milieu.Info(fmt.Sprintf("No payments found, exiting run"))
+		result = metrics.RunNoPayments
+		if isDryRun {
+			writeDryRunReport(ctx, milieu, runReport)
+		}
 		return
 	}
 
-	if isDryRun {
-		milieu.Info("In dry run mode, not inserting batch or executing wallet, dumping txn list for debugging")
-		for i, v := range payments {
-			milieu.Info(fmt.Sprintf("Index: %v, data: %v", i, v))
+	addresses := make([]string, 0, len(payments))
+	for _, payment := range payments {
+		addresses = append(addresses, payment.Address)
+	}
+	if err = checkCap(ctx, milieu, payoutCaps.CheckRun, addresses, addressCache, balanceCache); err != nil {
+		milieu.Info(err.Error())
+		runReport.CapBreach = err.Error()
+		if !isDryRun {
+			result = metrics.RunHalted
+			return
 		}
+	}
+
+	if isDryRun {
+		result = metrics.RunCompleted
+		milieu.Info("In dry run mode, not inserting batch or executing wallet, writing the report")
+		writeDryRunReport(ctx, milieu, runReport)
 		return
 	}
 
-	milieu.Info(fmt.Sprintf("%v/%v payments prepared for %v, inserting batch data", len(payments), len(balances), totalAmount))
+	if ctx.Err() != nil {
+		milieu.Info(fmt.Sprintf("Stopping (%v), not starting a new batch", stopReason(ctx)))
+		result = metrics.RunInterrupted
+		return
+	}
+
+	milieu.Info(fmt.Sprintf("%v/%v payments prepared for %v with %v in fees, inserting batch data", len(payments), len(balances), totalAmount, quote.BatchFee(len(payments))))
 
-	batchID, err := sql.CreateNewBatch(milieu, len(payments), totalAmount)
+	batchID, err := store.CreateNewBatch(ctx, len(payments), totalAmount)
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Info(err.Error())
 		return
 	}
 
+	metrics.BatchesTotal.Inc()
+	session.batches += 1
+	milieu.Info(fmt.Sprintf("Batch ID: %v, reserving balances", batchID))
+
+	if err = reservePayouts(ctx, milieu, payments, addressCache, balanceCache, approvalCache, batchID); err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return
+	}
+
+	sendPayments(ctx, milieu, batchID, payments, addressCache, balanceCache)
+	result = metrics.RunCompleted
+	if ctx.Err() != nil {
+		result = metrics.RunInterrupted
+	}
+}
+
+// sendPayments pushes the queued payments for a batch through the wallet in txnsPerBatch sized chunks, records the
+// results, and fetches the excess TX data along with the fee the wallet charged.  Used by both a fresh run and a
+// resumed batch.
+//
+// Cancelling ctx stops it before the next wallet send, leaving the rest of the batch queued.  A send that has started
+// is let finish along with its PSQL bookkeeping, both run on a context that is never cancelled, otherwise a shutdown
+// could leave coins sent that we have no record of.
+func sendPayments(ctx context.Context, milieu *core.Milieu, batchID int, payments []*tari_generated.PaymentRecipient, addressCache map[string]uint64, balanceCache map[string]uint64) {
 	milieu.Info(fmt.Sprintf("Batch ID: %v, starting txn send", batchID))
 
 	sentTransactions := make([]*tari_generated.TransferResult, 0)
@@ -196,18 +639,39 @@ func performPayouts(milieu *core.Milieu) {
 	batchCount := 0
 	var successAmount uint64 = 0
 	var failedAmount uint64 = 0
+	bookkeeping := context.WithoutCancel(ctx)
+	defer func() {
+		session.sent += successAmount
+		session.failed += failedAmount
+	}()
 
 	for _, payment := range payments {
-		blocked = milieu.GetRedis().Exists(context.Background(), haltTxnKey)
+		if ctx.Err() != nil {
+			// Nothing from the short list onwards has reached the wallet, those recipients stay queued
+			milieu.Info(fmt.Sprintf("Stopping (%v), the rest of batch %v stays queued, resume with --resume-batch %v", stopReason(ctx), batchID, batchID))
+			session.interrupted = append(session.interrupted, batchID)
+			paymentShortList = make([]*tari_generated.PaymentRecipient, 0)
+			break
+		}
+		blocked := milieu.GetRedis().Exists(context.Background(), haltTxnKey)
 		if blocked.Val() != 0 {
-			// We're blocked by the halt txn key in redis, report and return.
-			milieu.Info("Payout system halted due to redis key set")
+			// We're blocked by the halt txn key in redis, report and return.  Nothing from the short list onwards has
+			// reached the wallet, so those recipients stay queued and can be resumed.
+			milieu.Info(fmt.Sprintf("Payout system halted due to redis key set, resume with --resume-batch %v", batchID))
+			metrics.Halted.Set(1)
 			paymentShortList = make([]*tari_generated.PaymentRecipient, 0)
 			break
 		}
 		paymentShortList = append(paymentShortList, payment)
 		if len(paymentShortList) == txnsPerBatch {
-			txResults, err := walletGRPC.SendTransactions(paymentShortList)
+			if err := leaderLock.Check(); err != nil {
+				// Another instance may be taking over, the short list onwards stays queued for it to resume
+				milieu.CaptureException(err)
+				milieu.Error(fmt.Sprintf("Aborting batch %v: %v, resume with --resume-batch %v", batchID, err, batchID))
+				paymentShortList = make([]*tari_generated.PaymentRecipient, 0)
+				break
+			}
+			txResults, err := submitPayments(ctx, milieu, batchID, paymentShortList, addressCache, balanceCache)
 			if err != nil {
 				milieu.CaptureException(err)
 				milieu.Info(err.Error())
@@ -220,7 +684,7 @@ func performPayouts(milieu *core.Milieu) {
 				batchCount += 1
 				continue
 			}
-			localSuccess, localFailure, err := atomicBalanceUpdates(milieu, txResults, addressCache, balanceCache, batchID)
+			localSuccess, localFailure, err := atomicBalanceUpdates(bookkeeping, milieu, txResults, addressCache, balanceCache, batchID)
 			if err != nil {
 				milieu.CaptureException(err)
 				milieu.Info(err.Error())
@@ -244,8 +708,18 @@ func performPayouts(milieu *core.Milieu) {
 		}
 	}
 
+	if len(paymentShortList) > 0 && ctx.Err() != nil {
+		milieu.Info(fmt.Sprintf("Stopping (%v), the rest of batch %v stays queued, resume with --resume-batch %v", stopReason(ctx), batchID, batchID))
+		session.interrupted = append(session.interrupted, batchID)
+		paymentShortList = make([]*tari_generated.PaymentRecipient, 0)
+	}
 	if len(paymentShortList) > 0 {
-		txResults, err := walletGRPC.SendTransactions(paymentShortList)
+		if err := leaderLock.Check(); err != nil {
+			milieu.CaptureException(err)
+			milieu.Error(fmt.Sprintf("Aborting batch %v: %v, resume with --resume-batch %v", batchID, err, batchID))
+			return
+		}
+		txResults, err := submitPayments(ctx, milieu, batchID, paymentShortList, addressCache, balanceCache)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
@@ -255,7 +729,7 @@ func performPayouts(milieu *core.Milieu) {
 			}
 			return
 		}
-		localSuccess, localFailure, err := atomicBalanceUpdates(milieu, txResults, addressCache, balanceCache, batchID)
+		localSuccess, localFailure, err := atomicBalanceUpdates(bookkeeping, milieu, txResults, addressCache, balanceCache, batchID)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
@@ -271,8 +745,8 @@ func performPayouts(milieu *core.Milieu) {
 		successAmount += localSuccess
 		failedAmount += localFailure
 	}
-	milieu.Info("Done processing transaction results, updating batch data")
-	err = sql.UpdateBatchAmounts(milieu, batchID, successAmount, failedAmount)
+	milieu.Info(fmt.Sprintf("Done processing transaction results, %v sent, %v failed, updating batch data", successAmount, failedAmount))
+	err := store.RefreshBatchAmounts(bookkeeping, batchID)
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Info(err.Error())
@@ -280,19 +754,29 @@ func performPayouts(milieu *core.Milieu) {
 	milieu.Info("Done updating batch data, starting TX repeat scan.")
 
 	for _, v := range sentTransactions {
+		if ctx.Err() != nil {
+			milieu.Info(fmt.Sprintf("Stopping (%v), skipping the rest of the TX repeat scan, reconcile --apply will fill in the missing details and fees", stopReason(ctx)))
+			break
+		}
 		if !v.IsSuccess {
 			continue
 		}
-		txInfo, err := walletGRPC.GetTransactionInfoByID(v.TransactionId)
+		txInfo, err := walletClient.GetTransactionInfoByID(ctx, v.TransactionId)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
 			continue
 		}
-		if txInfo.Status == 11 {
+		if txInfo == nil || txInfo.Status == 11 {
 			continue
 		}
-		if err = sql.CreateTransactionDetail(milieu, txInfo); err != nil {
+		if err = store.SetTransactionFee(bookkeeping, v.TransactionId, txInfo.Fee); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		} else {
+			metrics.FeesTotal.Add(float64(txInfo.Fee))
+		}
+		if err = store.CreateTransactionDetail(bookkeeping, txInfo); err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
 			continue
@@ -301,10 +785,128 @@ func performPayouts(milieu *core.Milieu) {
 	milieu.Info("Done updating batch data, stored excess TX data, payout complete")
 }
 
+// daemonFlags holds the flags main reads directly, the settings a config file can change are read back with flagValue
+type daemonFlags struct {
+	config              *string
+	walletGRPCAddress   *string
+	fakeWallet          *bool
+	debugEnabled        *bool
+	payoutOnBoot        *bool
+	cronTime            *string
+	runOnce             *bool
+	dryRun              *bool
+	dryRunReport        *string
+	setTxnHalt          *bool
+	unsetTxnHalt        *bool
+	resumeBatch         *int
+	feeEstimator        *string
+	feePerGram          *uint64
+	feeMaxPerGram       *uint64
+	feeStepWeight       *uint64
+	feeTxWeight         *uint64
+	baseNodeGRPCAddress *string
+	releaseBatch        *int
+	leaderLockID        *int64
+	autoRepay           *bool
+	metricsListen       *string
+	eventChannel        *string
+	listApprovals       *bool
+	approvePayout       *uint64
+	rejectPayout        *uint64
+	approvalNote        *string
+	adminListen         *string
+	sqlQueryTimeout     *time.Duration
+	walletRetries       *int
+	walletRetryDelay    *time.Duration
+	walletRetryMaxDelay *time.Duration
+	walletTimeout       *time.Duration
+	tariNetwork         *string
+}
+
+// defineFlags registers every payoutDaemon flag on the default FlagSet
+func defineFlags() *daemonFlags {
+	f := &daemonFlags{}
+	f.config = flag.String("config", "", "YAML config file to read settings from, flags given on the command line win over it.  Reloaded on SIGHUP")
+	f.walletGRPCAddress = flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
+	f.fakeWallet = flag.Bool("fake-wallet", false, "Use an in-memory fake wallet instead of walletGRPCAddress, for local development only")
+	f.debugEnabled = flag.Bool("debug-enabled", false, "Enable debug logging")
+	f.payoutOnBoot = flag.Bool("payout-on-boot", false, "Perform payout on boot")
+	f.cronTime = flag.String("cron-time", "0 * * * *", "Cron time for payouts, runs every hour")
+	f.runOnce = flag.Bool("run-once", false, "Run once and exit")
+	f.dryRun = flag.Bool("dry-run", false, "Puts the system into dry-run mode, exits right before batch insert")
+	f.dryRunReport = flag.String("dry-run-report", "dry-run-report", "Path the dry-run report is written to, with .json and .csv appended")
+	flag.String("txn-msg", "", "Transaction message to attach to a send after its payment ID, max length 256 characters, cut short to fit")
+	flag.Int("batch-size", 50, "How many TXNs to submit to the wallet in a batch")
+	f.setTxnHalt = flag.Bool("set-txn-halt", false, "Set transaction halt flag in redis")
+	f.unsetTxnHalt = flag.Bool("unset-txn-halt", false, "Unset transaction halt flag in redis")
+	flag.Int("balance-select-order", 0, "Select balance order by, 0 for unsorted, 1 for highest, 2 for lowest")
+	f.resumeBatch = flag.Int("resume-batch", 0, "Send the queued recipients of a halted batch and exit")
+	f.feeEstimator = flag.String("fee-estimator", "static", "Fee-per-gram estimator, static or mempool")
+	f.feePerGram = flag.Uint64("fee-per-gram", 5, "Fee-per-gram for the static estimator, and the starting point for the mempool estimator")
+	f.feeMaxPerGram = flag.Uint64("fee-max-per-gram", 25, "Highest fee-per-gram the mempool estimator will use")
+	f.feeStepWeight = flag.Uint64("fee-step-weight", 100000, "Grams of unconfirmed mempool weight per extra gram of fee for the mempool estimator")
+	f.feeTxWeight = flag.Uint64("fee-tx-weight", 1000, "Weight in grams of a single payout transaction, the fee per recipient is this times the fee-per-gram")
+	f.baseNodeGRPCAddress = flag.String("base-node-grpc-address", "127.0.0.1:18142", "Tari base node GRPC address, used by the mempool fee estimator")
+	f.releaseBatch = flag.Int("release-batch", 0, "Credit the queued recipients of a halted batch back to their balances and exit")
+	f.leaderLockID = flag.Int64("leader-lock-id", 7210345602, "Postgres advisory lock key used to elect the leader, every instance of the same faucet must use the same key")
+	f.autoRepay = flag.Bool("auto-repay", true, "Credit payouts the wallet has cancelled or rejected back to their balance before each run")
+	f.metricsListen = flag.String("metrics-listen", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9100, disabled if empty")
+	flag.Uint64("cap-per-batch", 0, "Most that can be handed to the wallet in a single send, fees included, 0 for no cap")
+	flag.Uint64("cap-per-run", 0, "Most a single payout run can reserve, fees included, 0 for no cap")
+	flag.Uint64("cap-daily", 0, "Most that can be handed to the wallet over a rolling 24h, fees included, 0 for no cap")
+	flag.Uint64("cap-per-address-daily", 0, "Most a single address can be paid over a rolling 24h, fees included, 0 for no cap")
+	flag.Float64("anomaly-history-multiple", 0, "Quarantine a balance worth more than this many times its largest payout, 0 to disable")
+	flag.Uint64("anomaly-min-history", 3, "Payouts a balance needs before --anomaly-history-multiple applies, --anomaly-first-payout-max applies below it")
+	flag.Uint64("anomaly-first-payout-max", 0, "Quarantine a balance above this with less than --anomaly-min-history payouts, 0 to disable")
+	flag.Uint64("anomaly-jump-max", 0, "Quarantine a balance credited more than this within --anomaly-jump-window, 0 to disable")
+	flag.Duration("anomaly-jump-window", 24*time.Hour, "Window for --anomaly-jump-max")
+	flag.Int("anomaly-new-address-burst", 0, "Quarantine every new balance when more than this many were created within --anomaly-burst-window, 0 to disable")
+	flag.Duration("anomaly-burst-window", time.Hour, "Window for --anomaly-new-address-burst")
+	f.eventChannel = flag.String("event-channel", events.DefaultChannel, "Redis channel alerts are published on")
+	flag.Uint64("approval-threshold", 0, "Payouts of more than this, fees included, wait for an operator to approve them, 0 to disable")
+	f.listApprovals = flag.Bool("list-approvals", false, "List the payouts waiting on approval and exit")
+	f.approvePayout = flag.Uint64("approve-payout", 0, "Approve the payout approval with this ID and exit, it is paid by the next run")
+	f.rejectPayout = flag.Uint64("reject-payout", 0, "Reject the payout approval with this ID and exit, the balance is marked invalid")
+	f.approvalNote = flag.String("approval-note", "", "Note recorded with --approve-payout or --reject-payout")
+	f.adminListen = flag.String("admin-listen", "", "Address to serve the admin API on, e.g. 127.0.0.1:9101, disabled if empty.  Requires ADMIN_TOKEN")
+	f.sqlQueryTimeout = flag.Duration("sql-query-timeout", sql.DefaultQueryTimeout, "Longest a single PSQL query can take before it is abandoned, 0 for no limit")
+	f.walletRetries = flag.Int("wallet-send-retries", walletRetry.Retries, "Times to retry a wallet send that didn't reach the wallet or timed out, 0 to never retry")
+	f.walletRetryDelay = flag.Duration("wallet-retry-delay", walletRetry.Delay, "Wait before the first wallet send retry, doubling for each one after it")
+	f.walletRetryMaxDelay = flag.Duration("wallet-retry-max-delay", walletRetry.MaxDelay, "Longest wait between wallet send retries")
+	f.walletTimeout = flag.Duration("wallet-timeout", walletTimeout, "Longest a single wallet send can take before it is abandoned and the wallet checked before a retry, 0 for no limit")
+	flag.Duration("run-timeout", runTimeout, "Longest a payout run can take, once it is up no new batch or wallet send is started, 0 for no limit")
+	f.tariNetwork = flag.String("tari-network", "", "Tari network payout addresses must be for (mainnet, stagenet, nextnet, localnet, igor, esmeralda), empty accepts any")
+	return f
+}
+
 func main() {
-	psqlURL := getEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
-	redisURI := getEnv("REDIS_SERVER", "redis://redis:6379/0")
-	sentryURI := getEnv("SENTRY_SERVER", "")
+	flags := defineFlags()
+	flag.Parse()
+
+	// Then the config file, under anything given on the command line
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	settings := make(map[string]string)
+	if *flags.config != "" {
+		var err error
+		if settings, err = readConfig(*flags.config); err == nil {
+			err = applyConfig(settings, explicit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load config: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if err := validateSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(2)
+	}
+
+	psqlURL := configServer(settings, "psql-server", "postgres://postgres@localhost/postgres?sslmode=disable")
+	redisURI := configServer(settings, "redis-server", "redis://redis:6379/0")
+	sentryURI := configServer(settings, "sentry-server", "")
 
 	// Build Milieu
 	milieu, err := core.NewMilieu(&psqlURL, &redisURI, &sentryURI)
@@ -313,49 +915,131 @@ func main() {
 		milieu.Fatal(err.Error())
 	}
 
-	// Load config flags
-	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
-	debugEnabledPtr := flag.Bool("debug-enabled", false, "Enable debug logging")
-	payoutOnBootPtr := flag.Bool("payout-on-boot", false, "Perform payout on boot")
-	cronTimePtr := flag.String("cron-time", "0 * * * *", "Cron time for payouts, runs every hour")
-	runOncePtr := flag.Bool("run-once", false, "Run once and exit")
-	dryRunPtr := flag.Bool("dry-run", false, "Puts the system into dry-run mode, exits right before batch insert")
-	txnMsgPtr := flag.String("txn-msg", "", "Transaction message to attach to a send, max length 256 characters")
-	batchSizePtr := flag.Int("batch-size", 50, "How many TXNs to submit to the wallet in a batch")
-	settxnHalt := flag.Bool("set-txn-halt", false, "Set transaction halt flag in redis")
-	unsetTxnHalt := flag.Bool("unset-txn-halt", false, "Unset transaction halt flag in redis")
-	balanceSelectOrder := flag.Int("balance-select-order", 0, "Select balance order by, 0 for unsorted, 1 for highest, 2 for lowest")
+	postgresStore := sql.NewPostgresStore(milieu)
+	postgresStore.QueryTimeout = *flags.sqlQueryTimeout
+	walletRetry = wallet.RetryPolicy{Retries: *flags.walletRetries, Delay: *flags.walletRetryDelay, MaxDelay: *flags.walletRetryMaxDelay}
+	walletTimeout = *flags.walletTimeout
+	store = postgresStore
 
-	flag.Parse()
-	txnMsg = *txnMsgPtr
-	walletGRPC.InitWalletGRPC(*walletGRPCAddressPtr)
-	balanceSortOrder = *balanceSelectOrder
+	// SIGTERM and SIGINT stop new work, see shutdown.go
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if *flags.fakeWallet {
+		milieu.Warn("Using the in-memory fake wallet, nothing will be sent to the network but balances WILL be updated")
+		walletClient = wallet.NewFakeClient()
+	} else {
+		walletClient = wallet.NewGRPCClient(*flags.walletGRPCAddress)
+	}
+	walletClient = wallet.NewInstrumentedClient(walletClient, metrics.ObserveWallet)
 
-	txnsPerBatch = *batchSizePtr
+	if *flags.metricsListen != "" {
+		milieu.Info(fmt.Sprintf("Serving metrics on %v/metrics", *flags.metricsListen))
+		metrics.Serve(*flags.metricsListen, func(err error) {
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+		})
+	}
+	applyReloadableSettings()
+	eventChannel = *flags.eventChannel
+
+	switch *flags.feeEstimator {
+	case "static":
+		feePolicy = &fee.Policy{Estimator: &fee.StaticEstimator{PerGram: *flags.feePerGram}, TxWeight: *flags.feeTxWeight}
+	case "mempool":
+		feePolicy = &fee.Policy{Estimator: &fee.MempoolEstimator{
+			BaseNodeAddress: *flags.baseNodeGRPCAddress,
+			Base:            *flags.feePerGram,
+			Max:             *flags.feeMaxPerGram,
+			StepWeight:      *flags.feeStepWeight,
+		}, TxWeight: *flags.feeTxWeight}
+	default:
+		milieu.Fatal(fmt.Sprintf("Unknown fee estimator: %v", *flags.feeEstimator))
+	}
+
+	if *flags.tariNetwork != "" {
+		network, err := address.ParseNetwork(*flags.tariNetwork)
+		if err != nil {
+			milieu.Fatal(err.Error())
+		}
+		tariNetwork = &network
+	}
 
-	if *debugEnabledPtr {
+	if *flags.debugEnabled {
 		milieu.SetLogLevel(logrus.DebugLevel)
 	}
 
-	if *settxnHalt {
+	if *flags.setTxnHalt {
 		milieu.Info("Setting transaction halt flag in redis and exiting")
 		milieu.GetRedis().Set(context.Background(), haltTxnKey, 1, 0)
+		recordAudit(ctx, milieu, getEnv("USER", "payoutDaemon"), "set_halt", haltTxnKey, "payouts halted")
 		return
 	}
 
-	if *unsetTxnHalt {
+	if *flags.unsetTxnHalt {
 		milieu.Info("Unsetting transaction halt flag in redis and exiting")
 		milieu.GetRedis().Del(context.Background(), haltTxnKey)
+		recordAudit(ctx, milieu, getEnv("USER", "payoutDaemon"), "unset_halt", haltTxnKey, "payouts resumed")
+		return
+	}
+
+	if *flags.listApprovals {
+		printApprovals(ctx, milieu)
+		return
+	}
+
+	if *flags.approvePayout != 0 {
+		resolveApprovalFromCLI(ctx, milieu, *flags.approvePayout, sql.ApprovalApproved, *flags.approvalNote)
+		return
+	}
+
+	if *flags.rejectPayout != 0 {
+		resolveApprovalFromCLI(ctx, milieu, *flags.rejectPayout, sql.ApprovalRejected, *flags.approvalNote)
+		return
+	}
+
+	isDryRun = *flags.dryRun
+	dryRunReportPath = *flags.dryRunReport
+	autoRepay = *flags.autoRepay
+
+	leaderLock = leader.New(milieu.GetRawPGXPool(), *flags.leaderLockID)
+
+	// Settle anything a previous crash left behind before the cron gets a chance to run.
+	if !isDryRun && isLeader(milieu) {
+		if err = recoverPendingPayouts(ctx, milieu); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		}
+	}
+
+	if *flags.resumeBatch != 0 {
+		resumeBatch(ctx, milieu, *flags.resumeBatch)
+		return
+	}
+
+	if *flags.releaseBatch != 0 {
+		releaseBatch(ctx, milieu, *flags.releaseBatch)
 		return
 	}
 
-	isDryRun = *dryRunPtr
+	reportQueuedBatches(ctx, milieu)
+
+	var admin *http.Server
+	if *flags.adminListen != "" {
+		adminToken := getEnv("ADMIN_TOKEN", "")
+		if adminToken == "" {
+			milieu.Fatal("--admin-listen needs ADMIN_TOKEN to be set")
+		}
+		milieu.Info(fmt.Sprintf("Serving the admin API on %v/admin", *flags.adminListen))
+		admin = serveAdmin(ctx, milieu, *flags.adminListen, adminToken)
+	}
 
 	// Everything is setup, lets get to work.
-	if *payoutOnBootPtr || *runOncePtr {
-		performPayouts(milieu)
-		if *runOncePtr {
+	if *flags.payoutOnBoot || *flags.runOnce {
+		performPayouts(ctx, milieu)
+		if *flags.runOnce {
 			milieu.Info("Dry-run mode is enabled, exiting")
+			logSessionSummary(milieu)
 			os.Exit(0)
 		}
 	}
@@ -364,13 +1048,15 @@ func main() {
 
 	// Build the cron spinner
 	c := cron.New()
-	_, _ = c.AddFunc(*cronTimePtr, func() {
-		performPayouts(milieu)
-	})
-	c.Run()
-
-	// Idle loop!
-	for {
-		select {}
+	job := func() {
+		performPayouts(ctx, milieu)
+	}
+	entry, _ := c.AddFunc(*flags.cronTime, job)
+	if *flags.config != "" {
+		reloader := &configReloader{milieu: milieu, path: *flags.config, explicit: explicit, loaded: settings, cron: c, entry: entry, job: job}
+		reloader.watch()
 	}
+	c.Start()
+
+	waitForShutdown(ctx, stop, milieu, c, admin)
 }
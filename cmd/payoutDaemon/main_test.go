@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/fee"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/paymentid"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const testFeePerGram = 5
+
+// testFee is what the fake wallet charges each recipient at testFeePerGram, and what the quote deducts
+const testFee = testFeePerGram * wallet.FakeTxWeight
+
+type testLeader struct{}
+
+func (testLeader) TryAcquire() (bool, error) { return true, nil }
+func (testLeader) Check() error              { return nil }
+
+// setupDaemon points payoutDaemon at a fresh MemoryStore and FakeClient.  Nothing listens on the redis port, so every
+// redis call fails straight away, which payoutDaemon reads as no halt key and no bypasses.
+func setupDaemon(t *testing.T) (*core.Milieu, *sql.MemoryStore, *wallet.FakeClient) {
+	t.Helper()
+	redisURI := "redis://127.0.0.1:1/0?max_retries=-1&dial_timeout=100ms"
+	milieu, err := core.NewMilieu(nil, &redisURI, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryStore := sql.NewMemoryStore()
+	fakeWallet := wallet.NewFakeClient()
+	store = memoryStore
+	walletClient = fakeWallet
+	leaderLock = testLeader{}
+	feePolicy = &fee.Policy{Estimator: &fee.StaticEstimator{PerGram: testFeePerGram}, TxWeight: wallet.FakeTxWeight}
+	walletRetry = wallet.RetryPolicy{Retries: 2, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+	isDryRun = false
+	autoRepay = false
+	tariNetwork = nil
+	approvalThreshold = 0
+	return milieu, memoryStore, fakeWallet
+}
+
+// testAddress is a valid single esmeralda address, hex encoded, with every spend key byte set to key
+func testAddress(t *testing.T, key byte) string {
+	t.Helper()
+	raw := append([]byte{byte(address.Esmeralda), 0x01}, bytes.Repeat([]byte{key}, 32)...)
+	// Exactly one checksum byte is valid, find it rather than repeat the Damm checksum here
+	for checksum := 0; checksum < 256; checksum++ {
+		candidate := append(append([]byte(nil), raw...), byte(checksum))
+		if _, err := address.FromBytes(candidate); err == nil {
+			return hex.EncodeToString(candidate)
+		}
+	}
+	t.Fatal("no valid checksum found")
+	return ""
+}
+
+func addTestBalance(t *testing.T, memoryStore *sql.MemoryStore, key byte, balance uint64) (uint64, string) {
+	t.Helper()
+	addr := testAddress(t, key)
+	return memoryStore.AddBalance(sql.BalanceSqlRow{Address: addr, Balance: balance, Valid: true}), addr
+}
+
+func balanceOf(t *testing.T, memoryStore *sql.MemoryStore, balanceID uint64) uint64 {
+	t.Helper()
+	row, ok := memoryStore.Balance(balanceID)
+	if !ok {
+		t.Fatalf("balance %v not found", balanceID)
+	}
+	return row.Balance
+}
+
+// recipientOf returns the balance's recipient row in the latest batch
+func recipientOf(t *testing.T, memoryStore *sql.MemoryStore, balanceID uint64) sql.BatchRecipientSqlRow {
+	t.Helper()
+	ctx := context.Background()
+	batch, err := memoryStore.GetLatestBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := memoryStore.GetAllBatchRecipients(ctx, batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, recipient := range recipients {
+		if recipient.BalanceID == balanceID {
+			return recipient
+		}
+	}
+	t.Fatalf("no recipient for balance %v in batch %v", balanceID, batch.ID)
+	return sql.BatchRecipientSqlRow{}
+}
+
+func TestPerformPayouts(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	okID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	failedID, failedAddress := addTestBalance(t, memoryStore, 2, 200000)
+	zeroID, zeroAddress := addTestBalance(t, memoryStore, 3, 300000)
+	fakeWallet.ScriptAddress(failedAddress, wallet.FakeFailure)
+	fakeWallet.ScriptAddress(zeroAddress, wallet.FakeZeroTxID)
+
+	performPayouts(context.Background(), milieu)
+
+	// Sent, the whole balance is gone, the fee came out of what was sent
+	if balance := balanceOf(t, memoryStore, okID); balance != 0 {
+		t.Fatalf("paid balance is %v", balance)
+	}
+	recipient := recipientOf(t, memoryStore, okID)
+	if recipient.State != sql.RecipientSucceeded || recipient.TxID == 0 || recipient.SendAmount != 100000-testFee {
+		t.Fatalf("paid recipient: %+v", recipient)
+	}
+	transaction, err := memoryStore.GetTransaction(context.Background(), recipient.TxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transaction.Success || transaction.Amount != 100000 || transaction.BalanceID != okID || transaction.Fee != testFee {
+		t.Fatalf("paid transaction: %+v", transaction)
+	}
+
+	// Rejected by the wallet, credited back in full
+	if balance := balanceOf(t, memoryStore, failedID); balance != 200000 {
+		t.Fatalf("failed balance is %v, expected it credited back", balance)
+	}
+	recipient = recipientOf(t, memoryStore, failedID)
+	if recipient.State != sql.RecipientFailed {
+		t.Fatalf("failed recipient: %+v", recipient)
+	}
+	if transaction, err = memoryStore.GetTransaction(context.Background(), recipient.TxID); err != nil || transaction.Success {
+		t.Fatalf("failed transaction: %+v %v", transaction, err)
+	}
+
+	// Sent, but the wallet reported a TxID of 0, it is still recorded under a made up one
+	if balance := balanceOf(t, memoryStore, zeroID); balance != 0 {
+		t.Fatalf("zero TxID balance is %v", balance)
+	}
+	recipient = recipientOf(t, memoryStore, zeroID)
+	if recipient.State != sql.RecipientSucceeded || recipient.TxID == 0 {
+		t.Fatalf("zero TxID recipient: %+v", recipient)
+	}
+	if transaction, err = memoryStore.GetTransaction(context.Background(), recipient.TxID); err != nil || !transaction.Success {
+		t.Fatalf("zero TxID transaction: %+v %v", transaction, err)
+	}
+
+	sent := fakeWallet.Sent()
+	if len(sent) != 2 {
+		t.Fatalf("%v sent, expected 2", len(sent))
+	}
+	for _, payment := range sent {
+		if id, ok := paymentid.Parse([]byte(payment.UserPaymentId.GetUtf8String())); !ok || id.BatchID != recipient.BatchID {
+			t.Fatalf("payment %v sent without a payment ID for the batch", payment.Address)
+		}
+	}
+}
+
+func TestSendRetriedWhenNotSent(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	fakeWallet.FailNextSend(fmt.Errorf("%w: connection refused", wallet.ErrNotConnected), false)
+
+	performPayouts(context.Background(), milieu)
+
+	if len(fakeWallet.Sent()) != 1 {
+		t.Fatalf("%v sent, expected 1", len(fakeWallet.Sent()))
+	}
+	if recipient := recipientOf(t, memoryStore, balanceID); recipient.State != sql.RecipientSucceeded {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+	if balance := balanceOf(t, memoryStore, balanceID); balance != 0 {
+		t.Fatalf("balance is %v", balance)
+	}
+}
+
+func TestSendRetryFindsSentPayments(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	firstID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	secondID, _ := addTestBalance(t, memoryStore, 2, 200000)
+	// Timed out after the wallet built both, the retry has to find them rather than pay them twice
+	fakeWallet.FailNextSend(context.DeadlineExceeded, true)
+
+	performPayouts(context.Background(), milieu)
+
+	if len(fakeWallet.Sent()) != 2 {
+		t.Fatalf("%v sent, expected 2", len(fakeWallet.Sent()))
+	}
+	for _, balanceID := range []uint64{firstID, secondID} {
+		recipient := recipientOf(t, memoryStore, balanceID)
+		if recipient.State != sql.RecipientSucceeded || recipient.TxID == 0 {
+			t.Fatalf("recipient: %+v", recipient)
+		}
+		if balance := balanceOf(t, memoryStore, balanceID); balance != 0 {
+			t.Fatalf("balance %v is %v", balanceID, balance)
+		}
+	}
+}
+
+func TestSendRetryResendsMissingPayments(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	fakeWallet.FailNextSend(status.Error(codes.Unavailable, "connection dropped"), false)
+
+	performPayouts(context.Background(), milieu)
+
+	if len(fakeWallet.Sent()) != 1 {
+		t.Fatalf("%v sent, expected 1", len(fakeWallet.Sent()))
+	}
+	if recipient := recipientOf(t, memoryStore, balanceID); recipient.State != sql.RecipientSucceeded {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+}
+
+func TestSendNotRetriedWhenRejected(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	fakeWallet.FailNextSend(status.Error(codes.InvalidArgument, "bad recipient"), false)
+
+	performPayouts(context.Background(), milieu)
+
+	if len(fakeWallet.Sent()) != 0 {
+		t.Fatalf("%v sent, expected nothing", len(fakeWallet.Sent()))
+	}
+	// Left for recovery, which finds nothing in the wallet and credits it back
+	if recipient := recipientOf(t, memoryStore, balanceID); recipient.State != sql.RecipientSubmitted {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+}
+
+func TestRecoverSentPayout(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	// The wallet sent it but the error can't be placed, it must not be resent or credited back
+	fakeWallet.FailNextSend(errors.New("connection reset"), true)
+
+	performPayouts(context.Background(), milieu)
+
+	if len(fakeWallet.Sent()) != 1 {
+		t.Fatalf("%v sent, expected 1", len(fakeWallet.Sent()))
+	}
+	stale := recipientOf(t, memoryStore, balanceID)
+	if stale.State != sql.RecipientSubmitted {
+		t.Fatalf("recipient: %+v", stale)
+	}
+	if balance := balanceOf(t, memoryStore, balanceID); balance != 0 {
+		t.Fatalf("balance is %v, expected it to stay debited", balance)
+	}
+
+	if err := recoverPendingPayouts(context.Background(), milieu); err != nil {
+		t.Fatal(err)
+	}
+	recipient := recipientOf(t, memoryStore, balanceID)
+	if recipient.State != sql.RecipientSucceeded || recipient.TxID == 0 {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+	transaction, err := memoryStore.GetTransaction(context.Background(), recipient.TxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transaction.Success || transaction.Fee != testFee {
+		t.Fatalf("transaction: %+v", transaction)
+	}
+
+	// Anything still holding the submitted row must not be able to credit it back now
+	if err = releaseBatchRecipient(context.Background(), milieu, stale, "late release"); !errors.Is(err, sql.ErrRecipientMoved) {
+		t.Fatalf("got %v, expected %v", err, sql.ErrRecipientMoved)
+	}
+	if balance := balanceOf(t, memoryStore, balanceID); balance != 0 {
+		t.Fatalf("balance is %v after a late release", balance)
+	}
+	if len(fakeWallet.Sent()) != 1 {
+		t.Fatalf("%v sent, expected 1", len(fakeWallet.Sent()))
+	}
+}
+
+func TestRecoverUnsentPayout(t *testing.T) {
+	milieu, memoryStore, fakeWallet := setupDaemon(t)
+	balanceID, _ := addTestBalance(t, memoryStore, 1, 100000)
+	fakeWallet.FailNextSend(errors.New("stream reset"), false)
+
+	performPayouts(context.Background(), milieu)
+
+	if recipient := recipientOf(t, memoryStore, balanceID); recipient.State != sql.RecipientSubmitted {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+	if err := recoverPendingPayouts(context.Background(), milieu); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing in the wallet, so nothing left it, the reservation is handed back
+	if recipient := recipientOf(t, memoryStore, balanceID); recipient.State != sql.RecipientSkipped {
+		t.Fatalf("recipient: %+v", recipient)
+	}
+	if balance := balanceOf(t, memoryStore, balanceID); balance != 100000 {
+		t.Fatalf("balance is %v, expected it credited back", balance)
+	}
+	if len(memoryStore.Transactions()) != 0 {
+		t.Fatalf("transactions recorded: %+v", memoryStore.Transactions())
+	}
+}
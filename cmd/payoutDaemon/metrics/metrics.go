@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// Prometheus metrics for payoutDaemon, all amounts are in microTari.  Everything is registered on the default registry
+// up front so the series exist (at zero) from the first scrape, whether or not a run has happened yet.
+
+const namespace = "payout"
+
+// Run results, for RunsTotal
+const (
+	RunCompleted  = "completed"
+	RunHalted     = "halted"
+	RunNotLeader  = "not_leader"
+	RunNoPayments = "no_payments"
+	RunError      = "error"
+	// RunInterrupted is a run cut short by a shutdown, anything it didn't send is left queued
+	RunInterrupted = "interrupted"
+	// RunTimedOut is a run that hit --run-timeout, anything it didn't send is left queued
+	RunTimedOut = "timed_out"
+)
+
+var (
+	RunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Payout runs by how they ended.",
+	}, []string{"result"})
+	RunDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Wall time of a payout run, from the halt check to the last recorded result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	LastRunTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix time the last payout run finished.",
+	})
+	BalancesScanned = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "balances_scanned",
+		Help:      "Balances loaded by the last payout run.",
+	})
+	BalancesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "balances_skipped_total",
+		Help:      "Balances left out of a payout run, by reason.",
+	}, []string{"reason"})
+	PaymentsPrepared = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payments_prepared",
+		Help:      "Payments prepared by the last payout run.",
+	})
+	AmountPrepared = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "amount_prepared",
+		Help:      "Total debited from balances by the last payout run, fees included.",
+	})
+	FeePerGram = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fee_per_gram",
+		Help:      "Fee-per-gram quoted for the last payout run.",
+	})
+	BatchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_total",
+		Help:      "Batches created.",
+	})
+	WalletSendsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wallet_sends_total",
+		Help:      "Wallet send attempts, by outcome, a retried chunk counts once per attempt.",
+	}, []string{"result"})
+	WalletSendRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wallet_send_retries_total",
+		Help:      "Failed wallet sends, by whether they were retried, out of retries, rejected by the wallet, or found in the wallet on the check before a retry.",
+	}, []string{"result"})
+	RecipientsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recipients_total",
+		Help:      "Recipients resolved from wallet results, by outcome.",
+	}, []string{"result"})
+	AmountTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "amount_total",
+		Help:      "Amount debited for resolved recipients, fees included, by outcome.",
+	}, []string{"result"})
+	FeesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fees_total",
+		Help:      "Fees the wallet charged on successful payouts, as it reports them.",
+	})
+	BalanceUpdateErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "balance_update_errors_total",
+		Help:      "Wallet results that could not be recorded against balances, each one needs a human.",
+	})
+	BalanceUpdateDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "balance_update_duration_seconds",
+		Help:      "Time to record the wallet results of a chunk against balances.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	RecoveredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recovered_recipients_total",
+		Help:      "Submitted recipients settled by recovery, by outcome.",
+	}, []string{"result"})
+	RepaidTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repaid_total",
+		Help:      "Payouts dropped by the wallet and credited back to their balance.",
+	})
+	CapBreachesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cap_breaches_total",
+		Help:      "Payout caps hit, each one halts payouts, by cap.",
+	}, []string{"cap"})
+	Halted = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "halted",
+		Help:      "1 if the halt key was set at the last check.",
+	})
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 if this instance held the leader lock at the last check.",
+	})
+	WalletRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "wallet_request_duration_seconds",
+		Help:      "Latency of wallet GRPC calls, by method.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"method"})
+	WalletRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wallet_request_errors_total",
+		Help:      "Wallet GRPC calls that returned an error, by method.",
+	}, []string{"method"})
+)
+
+// ObserveWallet records a wallet call, it matches the hook taken by wallet.NewInstrumentedClient
+func ObserveWallet(method string, duration time.Duration, err error) {
+	WalletRequestDuration.WithLabelValues(method).Observe(duration.Seconds())
+	if err != nil {
+		WalletRequestErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func SetBool(gauge prometheus.Gauge, value bool) {
+	if value {
+		gauge.Set(1)
+	} else {
+		gauge.Set(0)
+	}
+}
+
+// Serve exposes /metrics on addr in the background, errors other than a clean shutdown go to onError
+func Serve(addr string, onError func(error)) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			onError(err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,69 @@
+package paymentid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/* paymentid is the UserPaymentId every payout is sent with, "tf:<batch ID>:<balance ID>" followed by --txn-msg if one
+is set.  The wallet stores it with the transaction, so a wallet transaction can be traced back to the batch
+recipient and `transactions` row it paid from wallet data alone, even when the TxID we stored is wrong or was made
+up because the wallet reported 0.  A balance is only ever in a batch once, so no two payouts share an ID.
+*/
+
+const prefix = "tf:"
+
+// MaxLength is the longest UserPaymentId the wallet accepts, in bytes
+const MaxLength = 256
+
+type ID struct {
+	BatchID   int
+	BalanceID uint64
+}
+
+func (id ID) String() string {
+	return fmt.Sprintf("%v%v:%v", prefix, id.BatchID, id.BalanceID)
+}
+
+// Format is the UserPaymentId for a payout, msg is cut short to keep it within MaxLength
+func Format(id ID, msg string) string {
+	paymentID := id.String()
+	if msg == "" {
+		return paymentID
+	}
+	paymentID = paymentID + " " + msg
+	if len(paymentID) <= MaxLength {
+		return paymentID
+	}
+	paymentID = paymentID[:MaxLength]
+	// Don't leave half a character at the end
+	for !utf8.ValidString(paymentID) {
+		paymentID = paymentID[:len(paymentID)-1]
+	}
+	return paymentID
+}
+
+// Parse reads the ID back from a wallet transaction's UserPaymentId, ok is false for anything payoutDaemon didn't send
+// or sent before payouts carried an ID
+func Parse(userPaymentID []byte) (id ID, ok bool) {
+	value, found := strings.CutPrefix(string(userPaymentID), prefix)
+	if !found {
+		return ID{}, false
+	}
+	value, _, _ = strings.Cut(value, " ")
+	batch, balance, found := strings.Cut(value, ":")
+	if !found {
+		return ID{}, false
+	}
+	batchID, err := strconv.Atoi(batch)
+	if err != nil || batchID <= 0 {
+		return ID{}, false
+	}
+	balanceID, err := strconv.ParseUint(balance, 10, 64)
+	if err != nil {
+		return ID{}, false
+	}
+	return ID{BatchID: batchID, BalanceID: balanceID}, true
+}
@@ -0,0 +1,45 @@
+package paymentid
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatParse(t *testing.T) {
+	id := ID{BatchID: 12, BalanceID: 18446744073709551615}
+	for _, msg := range []string{"", "Thanks for mining", "tf:1:2 looks like an ID"} {
+		formatted := Format(id, msg)
+		parsed, ok := Parse([]byte(formatted))
+		if !ok || parsed != id {
+			t.Fatalf("%q: got %+v %v, expected %+v", formatted, parsed, ok, id)
+		}
+	}
+	if Format(id, "") != "tf:12:18446744073709551615" {
+		t.Fatalf("got %q", Format(id, ""))
+	}
+}
+
+func TestFormatTruncates(t *testing.T) {
+	id := ID{BatchID: 1, BalanceID: 2}
+	formatted := Format(id, strings.Repeat("a", 300))
+	if len(formatted) != MaxLength {
+		t.Fatalf("got %v bytes, expected %v", len(formatted), MaxLength)
+	}
+	// A multi-byte character straddling the limit is dropped whole rather than cut in half
+	formatted = Format(id, strings.Repeat("a", MaxLength-len(id.String())-2)+"€")
+	if !utf8.ValidString(formatted) || len(formatted) > MaxLength || strings.HasSuffix(formatted, "€") {
+		t.Fatalf("got %q", formatted)
+	}
+	if parsed, ok := Parse([]byte(formatted)); !ok || parsed != id {
+		t.Fatalf("got %+v %v, expected %+v", parsed, ok, id)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, value := range []string{"", "Thanks for mining", "tf:", "tf:12", "tf:0:1", "tf:-1:1", "tf:a:1", "tf:1:b", "tf:1:-1", "TF:1:2"} {
+		if id, ok := Parse([]byte(value)); ok {
+			t.Fatalf("%q parsed as %+v", value, id)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/paymentid"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/repay"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"sort"
+)
+
+/* reconcile diffs the wallet's outbound transaction history against `transactions` (the ledger, what we debited) and
+	`transaction_details` (our copy of the wallet data), and can fix the discrepancies that have a safe fix.
+
+Inbound wallet transactions are ignored, they are faucet top ups and never touch a balance.  Diff is pure so it can be
+	run against any Store, Apply makes the change and writes an `audit_log` row for it, whether it worked or not.
+
+Wallet transactions are matched to the ledger by TxID, and by their payment ID (see paymentid) when the TxID doesn't
+	match anything, which catches a ledger row stored under the wrong TxID and a payout that was sent twice.
+*/
+
+type Kind string
+
+const (
+	// MissingDetail is a ledger row the wallet knows about, without the wallet data stored.  Fix: store it, along with
+	// the fee the wallet charged.
+	MissingDetail Kind = "missing_detail"
+	// MissingFee is a ledger row recorded with no fee, its send finished before the wallet reported the fee back.  Fix:
+	// store the fee the wallet charged.
+	MissingFee Kind = "missing_fee"
+	// MissingLedgerRow is stored wallet data without a ledger row, we don't know which balance paid for it.  Report only.
+	MissingLedgerRow Kind = "missing_ledger_row"
+	// AmountMismatch is a ledger row whose payout wasn't what the wallet sent.  Report only.
+	AmountMismatch Kind = "amount_mismatch"
+	// StatusDrift is stored wallet data that no longer matches the wallet.  Fix: store the wallet's view.
+	StatusDrift Kind = "status_drift"
+	// OrphanedWalletTx is an outbound wallet transaction we have no record of at all.  Report only.
+	OrphanedWalletTx Kind = "orphaned_wallet_tx"
+	// DoubleSpend is a ledger row still marked successful for a transaction the wallet dropped.  Fix: repay it.
+	DoubleSpend Kind = "double_spend"
+	// MissingWalletTx is a successful ledger row the wallet has no record of.  Report only.
+	MissingWalletTx Kind = "missing_wallet_tx"
+	// WrongTxID is a wallet transaction whose payment ID names a ledger row stored under another TxID.  Report only.
+	WrongTxID Kind = "wrong_tx_id"
+	// DuplicatePayment is a payment ID the wallet sent, and hasn't dropped, more than once.  Report only.
+	DuplicatePayment Kind = "duplicate_payment"
+)
+
+// Kinds lists every Kind in the order they are reported
+var Kinds = []Kind{DoubleSpend, DuplicatePayment, AmountMismatch, OrphanedWalletTx, WrongTxID, MissingLedgerRow, MissingWalletTx, MissingDetail, MissingFee, StatusDrift}
+
+type Discrepancy struct {
+	Kind        Kind
+	TxID        uint64
+	Description string
+	WalletTx    *tari_generated.TransactionInfo
+	Transaction *sql.TransactionSqlRow
+	Detail      *sql.TransactionDetail
+}
+
+// Fixable reports whether Apply can fix the discrepancy, everything else needs a human
+func (d Discrepancy) Fixable() bool {
+	switch d.Kind {
+	case MissingDetail, MissingFee, StatusDrift, DoubleSpend:
+		return true
+	}
+	return false
+}
+
+// legacyFee is what every payout was docked for the fee before fees were tracked, those payouts have no batch recipient
+const legacyFee = 5000
+
+// Diff classifies every discrepancy between the wallet history and what is stored, ordered by Kinds then TxID.
+// recipients are the succeeded batch recipients, for what each payout was meant to send.
+func Diff(walletTransactions []*tari_generated.TransactionInfo, transactions []sql.TransactionSqlRow, details []sql.TransactionDetail, recipients []sql.BatchRecipientSqlRow) []Discrepancy {
+	walletByID := make(map[uint64]*tari_generated.TransactionInfo, len(walletTransactions))
+	for _, walletTx := range walletTransactions {
+		if walletTx.Direction == tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND {
+			walletByID[walletTx.TxId] = walletTx
+		}
+	}
+	transactionByID := make(map[uint64]*sql.TransactionSqlRow, len(transactions))
+	transactionByPaymentID := make(map[paymentid.ID]*sql.TransactionSqlRow, len(transactions))
+	for i := range transactions {
+		transactionByID[transactions[i].ID] = &transactions[i]
+		transactionByPaymentID[paymentid.ID{BatchID: transactions[i].BatchID, BalanceID: transactions[i].BalanceID}] = &transactions[i]
+	}
+	sendAmounts := make(map[paymentid.ID]uint64, len(recipients))
+	for _, recipient := range recipients {
+		sendAmounts[paymentid.ID{BatchID: recipient.BatchID, BalanceID: recipient.BalanceID}] = recipient.SendAmount
+	}
+	detailByID := make(map[uint64]*sql.TransactionDetail, len(details))
+	for i := range details {
+		detailByID[details[i].ID] = &details[i]
+	}
+
+	result := make([]Discrepancy, 0)
+	add := func(kind Kind, txID uint64, description string) {
+		result = append(result, Discrepancy{
+			Kind:        kind,
+			TxID:        txID,
+			Description: description,
+			WalletTx:    walletByID[txID],
+			Transaction: transactionByID[txID],
+			Detail:      detailByID[txID],
+		})
+	}
+
+	// Ledger rows a wallet transaction was matched to by payment ID, they aren't missing from the wallet
+	matchedByPaymentID := make(map[uint64]bool)
+	sentByPaymentID := make(map[paymentid.ID][]uint64)
+	for txID, walletTx := range walletByID {
+		id, hasPaymentID := paymentid.Parse(walletTx.UserPaymentId)
+		if hasPaymentID && !wallet.IsDropped(walletTx) {
+			sentByPaymentID[id] = append(sentByPaymentID[id], txID)
+		}
+		transaction, hasTransaction := transactionByID[txID]
+		detail, hasDetail := detailByID[txID]
+		if !hasTransaction {
+			if paid, ok := transactionByPaymentID[id]; hasPaymentID && ok {
+				if _, paidInWallet := walletByID[paid.ID]; !paidInWallet {
+					matchedByPaymentID[paid.ID] = true
+				}
+				result = append(result, Discrepancy{
+					Kind:        WrongTxID,
+					TxID:        txID,
+					Description: fmt.Sprintf("wallet sent %v as %v, ledger has it as TxID %v", walletTx.Amount, id, paid.ID),
+					WalletTx:    walletTx,
+					Transaction: paid,
+					Detail:      detailByID[txID],
+				})
+				continue
+			}
+			if hasDetail {
+				add(MissingLedgerRow, txID, fmt.Sprintf("wallet sent %v, stored wallet data has no ledger row", walletTx.Amount))
+			} else {
+				add(OrphanedWalletTx, txID, fmt.Sprintf("wallet sent %v, no record of it", walletTx.Amount))
+			}
+			continue
+		}
+		if transaction.Success && wallet.IsDropped(walletTx) {
+			add(DoubleSpend, txID, fmt.Sprintf("ledger has %v as paid, wallet has it as %v (cancelled %v)", transaction.Amount, walletTx.Status, walletTx.IsCancelled))
+		}
+		// The fee column is what the wallet charged, what was meant to be sent is on the batch recipient
+		sendAmount, ok := sendAmounts[paymentid.ID{BatchID: transaction.BatchID, BalanceID: transaction.BalanceID}]
+		if !ok && transaction.Amount >= legacyFee {
+			sendAmount = transaction.Amount - legacyFee
+		}
+		if transaction.Success && sendAmount != walletTx.Amount {
+			add(AmountMismatch, txID, fmt.Sprintf("ledger debited %v to send %v, wallet sent %v", transaction.Amount, sendAmount, walletTx.Amount))
+		}
+		if !hasDetail {
+			add(MissingDetail, txID, "no stored wallet data")
+			continue
+		}
+		if transaction.Fee == 0 && walletTx.Fee > 0 {
+			add(MissingFee, txID, fmt.Sprintf("no fee recorded, wallet charged %v", walletTx.Fee))
+		}
+		if detail.Status != uint64(walletTx.Status.Number()) || detail.IsCancelled != walletTx.IsCancelled || detail.MinedAtHeight != walletTx.MinedInBlockHeight {
+			add(StatusDrift, txID, fmt.Sprintf("stored status %v cancelled %v height %v, wallet has %v cancelled %v height %v",
+				detail.Status, detail.IsCancelled, detail.MinedAtHeight, uint64(walletTx.Status.Number()), walletTx.IsCancelled, walletTx.MinedInBlockHeight))
+		}
+	}
+	for id, txIDs := range sentByPaymentID {
+		if len(txIDs) < 2 {
+			continue
+		}
+		sort.Slice(txIDs, func(i, j int) bool { return txIDs[i] < txIDs[j] })
+		for _, txID := range txIDs {
+			add(DuplicatePayment, txID, fmt.Sprintf("wallet sent %v %v times, as %v", id, len(txIDs), txIDs))
+		}
+	}
+	for txID, transaction := range transactionByID {
+		if _, ok := walletByID[txID]; !ok && transaction.Success && !matchedByPaymentID[txID] {
+			add(MissingWalletTx, txID, fmt.Sprintf("ledger has %v as paid to balance %v, wallet has no record of it", transaction.Amount, transaction.BalanceID))
+		}
+	}
+
+	order := make(map[Kind]int, len(Kinds))
+	for i, kind := range Kinds {
+		order[kind] = i
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return order[result[i].Kind] < order[result[j].Kind]
+		}
+		return result[i].TxID < result[j].TxID
+	})
+	return result
+}
+
+// Apply fixes a single discrepancy and records it in the audit log under runID, whether the fix worked or not
+func Apply(ctx context.Context, store sql.Store, d Discrepancy, runID string, actor string) error {
+	if !d.Fixable() {
+		return fmt.Errorf("%v for %v has no automatic fix", d.Kind, d.TxID)
+	}
+	var err error
+	var action string
+	switch d.Kind {
+	case MissingDetail:
+		action = "create_transaction_detail"
+		if err = store.CreateTransactionDetail(ctx, d.WalletTx); err == nil {
+			err = store.SetTransactionFee(ctx, d.TxID, d.WalletTx.Fee)
+		}
+	case MissingFee:
+		action = "set_transaction_fee"
+		err = store.SetTransactionFee(ctx, d.TxID, d.WalletTx.Fee)
+	case StatusDrift:
+		action = "update_transaction_detail_status"
+		err = store.UpdateTransactionDetailStatus(ctx, d.TxID, uint64(d.WalletTx.Status.Number()), d.WalletTx.IsCancelled, d.WalletTx.MinedInBlockHeight, d.Detail.Rechecked)
+	case DoubleSpend:
+		action = "repay"
+		_, err = repay.Repay(ctx, store, d.TxID, fmt.Sprintf("Reconcile: wallet reports the transaction as %v, credited back", d.WalletTx.Status))
+	}
+	detail := d.Description
+	if err != nil {
+		detail = fmt.Sprintf("%v, FAILED: %v", detail, err)
+	}
+	auditErr := store.CreateAuditEntry(ctx, sql.AuditEntry{
+		RunID:   runID,
+		Actor:   actor,
+		Action:  action,
+		Subject: fmt.Sprintf("transaction:%v", d.TxID),
+		Detail:  detail,
+	})
+	if err != nil {
+		return err
+	}
+	return auditErr
+}
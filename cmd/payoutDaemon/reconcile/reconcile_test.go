@@ -0,0 +1,146 @@
+package reconcile
+
+import (
+	"context"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/paymentid"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"testing"
+)
+
+const mined = tari_generated.TransactionStatus_TRANSACTION_STATUS_MINED_CONFIRMED
+
+// fixture builds the ledger, stored details and wallet history Diff is run against, every payout is for batch 1 with
+// the balance ID matching the TxID unless changed
+type fixture struct {
+	wallet       []*tari_generated.TransactionInfo
+	transactions []sql.TransactionSqlRow
+	details      []sql.TransactionDetail
+	recipients   []sql.BatchRecipientSqlRow
+}
+
+// payout records a clean payout of 1000 with a 5 fee, in the wallet, the ledger and the stored details
+func (f *fixture) payout(txID uint64) (*tari_generated.TransactionInfo, *sql.TransactionSqlRow, *sql.TransactionDetail) {
+	f.wallet = append(f.wallet, &tari_generated.TransactionInfo{
+		TxId:          txID,
+		Direction:     tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND,
+		Status:        mined,
+		Amount:        1000,
+		Fee:           5,
+		UserPaymentId: []byte(paymentid.Format(paymentid.ID{BatchID: 1, BalanceID: txID}, "")),
+	})
+	f.transactions = append(f.transactions, sql.TransactionSqlRow{ID: txID, Success: true, BalanceID: txID, BatchID: 1, Amount: 1005, Fee: 5})
+	f.details = append(f.details, sql.TransactionDetail{ID: txID, Status: uint64(mined.Number()), Amount: 1000, Fee: 5})
+	f.recipients = append(f.recipients, sql.BatchRecipientSqlRow{BatchID: 1, BalanceID: txID, Amount: 1005, SendAmount: 1000})
+	return f.wallet[len(f.wallet)-1], &f.transactions[len(f.transactions)-1], &f.details[len(f.details)-1]
+}
+
+func TestDiff(t *testing.T) {
+	f := &fixture{}
+	f.payout(1)
+
+	f.payout(2)
+	f.details = f.details[:len(f.details)-1]
+
+	_, transaction, _ := f.payout(3)
+	transaction.Fee = 0
+
+	_, _, detail := f.payout(4)
+	detail.MinedAtHeight = 100
+
+	walletTx, _, detail := f.payout(5)
+	walletTx.IsCancelled = true
+	detail.IsCancelled = true
+
+	walletTx, _, _ = f.payout(6)
+	walletTx.Amount = 900
+
+	// Neither in the ledger nor stored, without a payment ID to place it
+	f.wallet = append(f.wallet, &tari_generated.TransactionInfo{TxId: 7, Direction: tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND, Amount: 1000})
+	// Stored but not in the ledger
+	f.wallet = append(f.wallet, &tari_generated.TransactionInfo{TxId: 8, Direction: tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND, Amount: 1000})
+	f.details = append(f.details, sql.TransactionDetail{ID: 8})
+
+	f.payout(9)
+	f.wallet = f.wallet[:len(f.wallet)-1]
+
+	// The ledger has balance 10's payout as TxID 10, the wallet sent it as 11
+	walletTx, _, _ = f.payout(10)
+	walletTx.TxId = 11
+
+	// Balance 12 was sent twice, the second send was never recorded
+	walletTx, _, _ = f.payout(12)
+	f.wallet = append(f.wallet, &tari_generated.TransactionInfo{TxId: 13, Direction: walletTx.Direction, Status: mined, Amount: 1000, Fee: 5, UserPaymentId: walletTx.UserPaymentId})
+
+	// Inbound transactions are top ups, never a discrepancy
+	f.wallet = append(f.wallet, &tari_generated.TransactionInfo{TxId: 14, Direction: tari_generated.TransactionDirection_TRANSACTION_DIRECTION_INBOUND, Amount: 1 << 30})
+
+	// From before batch recipients, checked against the balance less the legacy fee
+	f.payout(15)
+	f.recipients = f.recipients[:len(f.recipients)-1]
+	f.transactions[len(f.transactions)-1].Amount = 1000 + legacyFee
+
+	expected := []struct {
+		kind Kind
+		txID uint64
+	}{
+		{DoubleSpend, 5},
+		{DuplicatePayment, 12},
+		{DuplicatePayment, 13},
+		{AmountMismatch, 6},
+		{OrphanedWalletTx, 7},
+		{WrongTxID, 11},
+		{WrongTxID, 13},
+		{MissingLedgerRow, 8},
+		{MissingWalletTx, 9},
+		{MissingDetail, 2},
+		{MissingFee, 3},
+		{StatusDrift, 4},
+	}
+	discrepancies := Diff(f.wallet, f.transactions, f.details, f.recipients)
+	if len(discrepancies) != len(expected) {
+		t.Fatalf("got %v discrepancies, expected %v: %+v", len(discrepancies), len(expected), discrepancies)
+	}
+	for i, d := range discrepancies {
+		if d.Kind != expected[i].kind || d.TxID != expected[i].txID {
+			t.Fatalf("discrepancy %v: got %v %v, expected %v %v", i, d.Kind, d.TxID, expected[i].kind, expected[i].txID)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	store := sql.NewMemoryStore()
+	f := &fixture{}
+	_, transaction, _ := f.payout(1)
+	transaction.Fee = 0
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.CreateNewTransaction(ctx, txn, 1, true, "", 1, 1, transaction.Amount, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	discrepancies := Diff(f.wallet, f.transactions, f.details, f.recipients)
+	if len(discrepancies) != 1 || discrepancies[0].Kind != MissingFee {
+		t.Fatalf("got %+v", discrepancies)
+	}
+	if err = Apply(ctx, store, discrepancies[0], "test", "tester"); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := store.GetTransaction(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded.Fee != 5 {
+		t.Fatalf("got fee %v, expected 5", recorded.Fee)
+	}
+
+	if err = Apply(ctx, store, Discrepancy{Kind: AmountMismatch, TxID: 1}, "test", "tester"); err == nil {
+		t.Fatal("applied a discrepancy that has no fix")
+	}
+}
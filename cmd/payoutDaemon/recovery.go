@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/metrics"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/paymentid"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+)
+
+/* Recovery of submitted payouts
+
+Every payout is queued in `payment_batch_recipients` (and debited from `balances`) before the wallet is contacted, is
+	flagged as submitted right before the wallet call, and is resolved in the same PSQL txn that records the wallet
+	result.  If we crash, OOM, or lose PSQL/the wallet between those points the recipient is left as submitted.  Before
+	we start another run we walk the submitted recipients and look for a matching outbound transaction in the wallet:
+	1. Exactly one match, the coins left the wallet, record the TxID in `transactions` and mark it succeeded.
+	2. No match, the coins never left the wallet, credit the balance back and mark it skipped.
+	3. More than one match, we can't tell which one is ours, leave it for a human.  The balance stays debited so it can't
+		be paid twice in the meantime.
+A send carrying a payment ID (see paymentid) only matches the recipient it names, sends from before payouts carried
+	one are matched on amount, address and time.
+Queued recipients are left alone, they never reached the wallet and are picked up by --resume-batch or --release-batch.
+*/
+
+// walletTxMatchesPending checks if a wallet transaction could be the send for a submitted recipient
+func walletTxMatchesPending(walletTx *tari_generated.TransactionInfo, pending sql.BatchRecipientSqlRow) bool {
+	if walletTx.Direction != tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND {
+		return false
+	}
+	// A payment ID says exactly which recipient a send was for, only sends from before payouts carried one are matched
+	// on amount, address and time
+	if id, ok := paymentid.Parse(walletTx.UserPaymentId); ok {
+		return id.BatchID == pending.BatchID && id.BalanceID == pending.BalanceID
+	}
+	if walletTx.Amount != pending.SendAmount {
+		return false
+	}
+	// Older wallets don't report the destination, only rule a transaction out on it when they do
+	if len(walletTx.DestAddress) > 0 {
+		if parsed, err := address.Parse(pending.Address); err == nil && !bytes.Equal(parsed.Bytes(), walletTx.DestAddress) {
+			return false
+		}
+	}
+	// Wallet timestamps are in seconds, the recipient is always flagged as submitted before the send so allow for
+	// truncation.
+	return int64(walletTx.Timestamp) >= pending.DateUpdated.Unix()-1
+}
+
+// matchPendingPayouts finds the wallet transactions that could be the send for each submitted recipient, leaving out
+// any already recorded in `transactions`.  A transaction that is the only match for a recipient is claimed by it, so
+// two reservations for the same amount can't both be settled by the same send.
+func matchPendingPayouts(ctx context.Context, pendingPayouts []sql.BatchRecipientSqlRow, walletTransactions []*tari_generated.TransactionInfo) ([][]*tari_generated.TransactionInfo, error) {
+	possible := make([][]*tari_generated.TransactionInfo, len(pendingPayouts))
+	txIDs := make([]uint64, 0)
+	for i, pending := range pendingPayouts {
+		for _, walletTx := range walletTransactions {
+			if walletTxMatchesPending(walletTx, pending) {
+				possible[i] = append(possible[i], walletTx)
+				txIDs = append(txIDs, walletTx.TxId)
+			}
+		}
+	}
+	recorded, err := store.GetRecordedTransactionIDs(ctx, txIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([][]*tari_generated.TransactionInfo, len(pendingPayouts))
+	claimed := make(map[uint64]bool)
+	for i := range pendingPayouts {
+		candidates := make([]*tari_generated.TransactionInfo, 0)
+		for _, walletTx := range possible[i] {
+			if claimed[walletTx.TxId] || recorded[walletTx.TxId] {
+				continue
+			}
+			candidates = append(candidates, walletTx)
+		}
+		if len(candidates) == 1 {
+			claimed[candidates[0].TxId] = true
+		}
+		matches[i] = candidates
+	}
+	return matches, nil
+}
+
+// releaseBatchRecipient credits the reservation back to the balance, for recipients that never left the wallet
+func releaseBatchRecipient(ctx context.Context, milieu *core.Milieu, pending sql.BatchRecipientSqlRow, reason string) error {
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	// Only credit it once the recipient has provably moved, a concurrent release or recovery fails here instead
+	if err = store.ResolveBatchRecipient(ctx, txn, pending.BatchID, pending.BalanceID, pending.State, sql.RecipientSkipped, 0, reason); err != nil {
+		return err
+	}
+	if err = store.IncreaseBalance(ctx, txn, pending.BalanceID, pending.Amount, sql.LedgerRepay, fmt.Sprintf("batch:%v", pending.BatchID)); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// finalizeBatchRecipient records a wallet transaction found for a submitted recipient
+func finalizeBatchRecipient(ctx context.Context, milieu *core.Milieu, pending sql.BatchRecipientSqlRow, walletTx *tari_generated.TransactionInfo) error {
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	if walletTx.IsCancelled {
+		// The wallet built it but it will never be mined, record it as failed and hand the coins back.
+		if err = store.CreateNewTransaction(ctx, txn, walletTx.TxId, false, "Transaction cancelled, recovered from submitted payout", pending.BalanceID, pending.BatchID, pending.Amount, 0); err != nil {
+			return err
+		}
+		if err = store.ResolveBatchRecipient(ctx, txn, pending.BatchID, pending.BalanceID, pending.State, sql.RecipientFailed, walletTx.TxId, "Transaction cancelled"); err != nil {
+			return err
+		}
+		if err = store.IncreaseBalance(ctx, txn, pending.BalanceID, pending.Amount, sql.LedgerRepay, fmt.Sprintf("tx:%v", walletTx.TxId)); err != nil {
+			return err
+		}
+		return txn.Commit()
+	}
+	if err = store.CreateNewTransaction(ctx, txn, walletTx.TxId, true, "", pending.BalanceID, pending.BatchID, pending.Amount, walletTx.Fee); err != nil {
+		return err
+	}
+	if err = store.ResolveBatchRecipient(ctx, txn, pending.BatchID, pending.BalanceID, pending.State, sql.RecipientSucceeded, walletTx.TxId, ""); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// recoverPendingPayouts reconciles any submitted recipients left over from a previous run against the wallet, see above.
+func recoverPendingPayouts(ctx context.Context, milieu *core.Milieu) error {
+	pendingPayouts, err := store.GetAllBatchRecipientsByState(ctx, sql.RecipientSubmitted)
+	if err != nil {
+		return err
+	}
+	if len(pendingPayouts) == 0 {
+		return nil
+	}
+	milieu.Info(fmt.Sprintf("%v submitted payouts found, reconciling against the wallet", len(pendingPayouts)))
+
+	walletTransactions, err := walletClient.GetTransactionsInBlock(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	// Batches we've changed a recipient in, their amounts need refreshing once we're done.
+	touchedBatches := make(map[int]bool)
+
+	matches, err := matchPendingPayouts(ctx, pendingPayouts, walletTransactions)
+	if err != nil {
+		return err
+	}
+	for i, pending := range pendingPayouts {
+		if ctx.Err() != nil {
+			// Shutting down, the rest stay submitted for the next start
+			break
+		}
+		candidates := matches[i]
+		switch len(candidates) {
+		case 0:
+			milieu.Info(fmt.Sprintf("No wallet transaction found for payout %v (batch %v, balance %v), releasing %v", pending.ID, pending.BatchID, pending.BalanceID, pending.Amount))
+			if err = releaseBatchRecipient(ctx, milieu, pending, "No wallet transaction found, recovered from submitted payout"); err != nil {
+				milieu.CaptureException(err)
+				milieu.Info(err.Error())
+				continue
+			}
+			touchedBatches[pending.BatchID] = true
+			metrics.RecoveredTotal.WithLabelValues("released").Inc()
+		case 1:
+			milieu.Info(fmt.Sprintf("Wallet transaction %v found for payout %v (batch %v, balance %v), recording", candidates[0].TxId, pending.ID, pending.BatchID, pending.BalanceID))
+			if err = finalizeBatchRecipient(ctx, milieu, pending, candidates[0]); err != nil {
+				milieu.CaptureException(err)
+				milieu.Info(err.Error())
+				continue
+			}
+			touchedBatches[pending.BatchID] = true
+			if candidates[0].IsCancelled {
+				metrics.RecoveredTotal.WithLabelValues("failed").Inc()
+			} else {
+				metrics.RecoveredTotal.WithLabelValues("succeeded").Inc()
+				metrics.FeesTotal.Add(float64(candidates[0].Fee))
+				milieu.GetRedis().Del(context.Background(), bypassKey(pending.Address))
+			}
+		default:
+			err = fmt.Errorf("payout %v (batch %v, balance %v) matches %v wallet transactions, manual review required", pending.ID, pending.BatchID, pending.BalanceID, len(candidates))
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+			metrics.RecoveredTotal.WithLabelValues("ambiguous").Inc()
+		}
+	}
+	// Only the totals, but they should match what was committed above even if we were stopped part way
+	for batchID := range touchedBatches {
+		if err = store.RefreshBatchAmounts(context.WithoutCancel(ctx), batchID); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,63 @@
+package repay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+)
+
+/* repay credits a payout back to its balance once the wallet has dropped it (cancelled, expired or rejected).
+
+Everything happens in a single PSQL txn:
+	1. Flag the `transactions` row as failed, only if it is still successful.  This is the idempotency guard, the row is
+		locked until the txn ends, so of any number of concurrent or repeated repays exactly one sees it flip.
+	2. Set `repaid` on the `transaction_details` row, if there is one.
+	3. Move the batch recipient to failed.
+	4. Credit the full debited amount back to the balance, the fee was never paid on a dropped transaction.
+Any failure rolls all of it back, a transaction is credited exactly once or not at all.
+*/
+
+// ErrNotRepayable is returned for a transaction that has already been repaid, or was never a successful send
+var ErrNotRepayable = errors.New("repay: transaction is not a successful payout, it may already have been repaid")
+
+// Repay credits a dropped payout back to its balance, returning the transaction as it was before the repay
+func Repay(ctx context.Context, store sql.Store, txID uint64, reason string) (sql.TransactionSqlRow, error) {
+	transaction, err := store.GetTransaction(ctx, txID)
+	if err != nil {
+		return transaction, err
+	}
+	txn, err := store.Begin(ctx)
+	if err != nil {
+		return transaction, err
+	}
+	defer txn.Rollback()
+	failed, err := store.FailTransaction(ctx, txn, txID, reason)
+	if err != nil {
+		return transaction, err
+	}
+	if !failed {
+		return transaction, ErrNotRepayable
+	}
+	if err = store.SetTransactionDetailRepaid(ctx, txn, txID); err != nil {
+		return transaction, err
+	}
+	if transaction.BatchID != 0 {
+		// Batches from before recipients were tracked have no row to move, FailTransaction is the guard here
+		err = store.ResolveBatchRecipient(ctx, txn, transaction.BatchID, transaction.BalanceID, sql.RecipientSucceeded, sql.RecipientFailed, txID, reason)
+		if err != nil && !errors.Is(err, sql.ErrRecipientMoved) {
+			return transaction, err
+		}
+	}
+	if err = store.IncreaseBalance(ctx, txn, transaction.BalanceID, transaction.Amount, sql.LedgerRepay, fmt.Sprintf("tx:%v", txID)); err != nil {
+		return transaction, err
+	}
+	if err = txn.Commit(); err != nil {
+		return transaction, err
+	}
+	if transaction.BatchID != 0 {
+		// Only the batch totals, the repay itself has already been committed
+		_ = store.RefreshBatchAmounts(ctx, transaction.BatchID)
+	}
+	return transaction, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/metrics"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/repay"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+)
+
+/* Repaying dropped payouts
+
+A payout the wallet accepted can still be dropped later, a pending send can expire or be cancelled, or the network can
+	reject it.  Before every run we walk the wallet history for outbound transactions in that state which we still
+	have recorded as successful, and credit them back with repay.Repay.  The next run then pays the balance again.
+*/
+
+func repayDroppedPayouts(ctx context.Context, milieu *core.Milieu) error {
+	walletTransactions, err := walletClient.GetTransactionsInBlock(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, walletTx := range walletTransactions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if walletTx.Direction != tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND || !wallet.IsDropped(walletTx) {
+			continue
+		}
+		// Cheap check first, the guard inside Repay is what actually stops a second credit
+		transaction, err := store.GetTransaction(ctx, walletTx.TxId)
+		if errors.Is(err, sql.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+			continue
+		}
+		if !transaction.Success {
+			continue
+		}
+		reason := fmt.Sprintf("Dropped by the wallet with status %v, credited back", walletTx.Status)
+		_, err = repay.Repay(ctx, store, walletTx.TxId, reason)
+		if errors.Is(err, repay.ErrNotRepayable) {
+			continue
+		}
+		if err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+			continue
+		}
+		metrics.RepaidTotal.Inc()
+		milieu.Warn(fmt.Sprintf("Transaction %v for balance %v was dropped by the wallet, credited %v back", walletTx.TxId, transaction.BalanceID, transaction.Amount))
+	}
+	return nil
+}
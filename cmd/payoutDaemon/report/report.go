@@ -0,0 +1,157 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"io"
+	"strconv"
+	"time"
+)
+
+/* report is what a dry run would have done, in a form that can be reviewed or attached to a change ticket before the
+real thing: every recipient and why it was included, every balance that was left out and why, the totals, and how
+it compares with the last real batch.  All amounts are in microTari, Amount is what a balance is debited and
+SendAmount what reaches the address, the difference is the fee.
+*/
+
+// Why a recipient was included
+const (
+	MinimumMet = "minimum_met"
+	Bypass     = "bypass"
+)
+
+type Recipient struct {
+	BalanceID  uint64 `json:"balance_id"`
+	Address    string `json:"address"`
+	Amount     uint64 `json:"amount"`
+	SendAmount uint64 `json:"send_amount"`
+	Fee        uint64 `json:"fee"`
+	Reason     string `json:"reason"`
+	ApprovalID uint64 `json:"approval_id,omitempty"`
+}
+
+type Skipped struct {
+	BalanceID uint64 `json:"balance_id"`
+	Address   string `json:"address"`
+	Balance   uint64 `json:"balance"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+type Totals struct {
+	Balances   int    `json:"balances"`
+	Recipients int    `json:"recipients"`
+	Skipped    int    `json:"skipped"`
+	Amount     uint64 `json:"amount"`
+	SendAmount uint64 `json:"send_amount"`
+	Fees       uint64 `json:"fees"`
+}
+
+// Comparison is the last real batch next to this run, Added and Removed are addresses in one but not the other
+type Comparison struct {
+	BatchID     int       `json:"batch_id"`
+	DateAdded   time.Time `json:"date_added"`
+	Recipients  int       `json:"recipients"`
+	Amount      uint64    `json:"amount"`
+	AmountDelta int64     `json:"amount_delta"`
+	Added       []string  `json:"added"`
+	Removed     []string  `json:"removed"`
+}
+
+type Report struct {
+	GeneratedAt   time.Time   `json:"generated_at"`
+	FeePerGram    uint64      `json:"fee_per_gram"`
+	Recipients    []Recipient `json:"recipients"`
+	Skipped       []Skipped   `json:"skipped"`
+	Totals        Totals      `json:"totals"`
+	CapBreach     string      `json:"cap_breach,omitempty"`
+	PreviousBatch *Comparison `json:"previous_batch"`
+}
+
+func New(feePerGram uint64, balances int) *Report {
+	return &Report{
+		GeneratedAt: time.Now(),
+		FeePerGram:  feePerGram,
+		Recipients:  make([]Recipient, 0),
+		Skipped:     make([]Skipped, 0),
+		Totals:      Totals{Balances: balances},
+	}
+}
+
+func (r *Report) AddRecipient(recipient Recipient) {
+	r.Recipients = append(r.Recipients, recipient)
+	r.Totals.Recipients += 1
+	r.Totals.Amount += recipient.Amount
+	r.Totals.SendAmount += recipient.SendAmount
+	r.Totals.Fees += recipient.Fee
+}
+
+func (r *Report) AddSkipped(skipped Skipped) {
+	r.Skipped = append(r.Skipped, skipped)
+	r.Totals.Skipped += 1
+}
+
+// Compare fills in PreviousBatch from the batch and its recipients, call it once every recipient has been added
+func (r *Report) Compare(batch sql.BatchSqlRow, recipients []sql.BatchRecipientSqlRow) {
+	previous := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		previous[recipient.Address] = true
+	}
+	current := make(map[string]bool, len(r.Recipients))
+	comparison := &Comparison{
+		BatchID:     batch.ID,
+		DateAdded:   batch.DateAdded,
+		Recipients:  len(recipients),
+		Amount:      batch.Amount,
+		AmountDelta: int64(r.Totals.Amount) - int64(batch.Amount),
+		Added:       make([]string, 0),
+		Removed:     make([]string, 0),
+	}
+	for _, recipient := range r.Recipients {
+		current[recipient.Address] = true
+		if !previous[recipient.Address] {
+			comparison.Added = append(comparison.Added, recipient.Address)
+		}
+	}
+	for _, recipient := range recipients {
+		if !current[recipient.Address] {
+			comparison.Removed = append(comparison.Removed, recipient.Address)
+		}
+	}
+	r.PreviousBatch = comparison
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes a row per recipient and skipped balance, then a totals row, and a previous_batch row if there was one
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	rows := [][]string{{"kind", "balance_id", "address", "amount", "send_amount", "fee", "reason", "detail"}}
+	for _, recipient := range r.Recipients {
+		detail := ""
+		if recipient.ApprovalID != 0 {
+			detail = fmt.Sprintf("approval %v", recipient.ApprovalID)
+		}
+		rows = append(rows, []string{"recipient", u(recipient.BalanceID), recipient.Address, u(recipient.Amount), u(recipient.SendAmount), u(recipient.Fee), recipient.Reason, detail})
+	}
+	for _, skipped := range r.Skipped {
+		rows = append(rows, []string{"skipped", u(skipped.BalanceID), skipped.Address, u(skipped.Balance), "", "", skipped.Reason, skipped.Detail})
+	}
+	rows = append(rows, []string{"total", "", "", u(r.Totals.Amount), u(r.Totals.SendAmount), u(r.Totals.Fees), r.CapBreach,
+		fmt.Sprintf("%v recipients, %v skipped of %v balances", r.Totals.Recipients, r.Totals.Skipped, r.Totals.Balances)})
+	if r.PreviousBatch != nil {
+		rows = append(rows, []string{"previous_batch", strconv.Itoa(r.PreviousBatch.BatchID), "", u(r.PreviousBatch.Amount), "", "", "",
+			fmt.Sprintf("%v recipients, %v difference, %v added, %v removed", r.PreviousBatch.Recipients, r.PreviousBatch.AmountDelta, len(r.PreviousBatch.Added), len(r.PreviousBatch.Removed))})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
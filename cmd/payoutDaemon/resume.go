@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+)
+
+/* Resuming halted batches
+
+A batch that stops part way through (halt key, wallet error, process kill) leaves its unsent recipients queued in
+	`payment_batch_recipients` with their balances still reserved.  --resume-batch sends exactly those recipients under
+	the original batch ID, --release-batch gives up on them and credits the reservations back so the next regular run
+	can pick the balances up fresh.  Submitted recipients are never resent, they belong to recoverPendingPayouts.
+*/
+
+// reportQueuedBatches logs every batch that still has queued recipients, so a halted batch doesn't get forgotten
+func reportQueuedBatches(ctx context.Context, milieu *core.Milieu) {
+	queued, err := store.GetAllBatchRecipientsByState(ctx, sql.RecipientQueued)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return
+	}
+	batchCounts := make(map[int]int)
+	for _, recipient := range queued {
+		batchCounts[recipient.BatchID] += 1
+	}
+	for batchID, count := range batchCounts {
+		milieu.Warn(fmt.Sprintf("Batch %v has %v queued recipients, use --resume-batch %v to send them or --release-batch %v to credit them back", batchID, count, batchID, batchID))
+	}
+}
+
+func resumeBatch(ctx context.Context, milieu *core.Milieu, batchID int) {
+	if !runMutex.TryLock() {
+		return
+	}
+	defer runMutex.Unlock()
+	if !isLeader(milieu) {
+		return
+	}
+	blocked := milieu.GetRedis().Exists(context.Background(), haltTxnKey)
+	if blocked.Val() != 0 {
+		milieu.Info("Payout system halted due to redis key set, unset it before resuming a batch")
+		return
+	}
+
+	recipients, err := store.GetBatchRecipients(ctx, batchID, sql.RecipientQueued)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return
+	}
+	if len(recipients) == 0 {
+		milieu.Info(fmt.Sprintf("No queued recipients in batch %v, nothing to resume", batchID))
+		return
+	}
+
+	addressCache := make(map[string]uint64)
+	balanceCache := make(map[string]uint64)
+	payments := make([]*tari_generated.PaymentRecipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		// Resend at the fee the recipient was reserved with, the amounts have already been debited
+		payments = append(payments, newPaymentRecipient(recipient.Address, recipient.SendAmount, recipient.FeePerGram))
+		addressCache[recipient.Address] = recipient.BalanceID
+		balanceCache[recipient.Address] = recipient.Amount
+	}
+
+	milieu.Info(fmt.Sprintf("Resuming batch %v with %v queued recipients", batchID, len(recipients)))
+	sendPayments(ctx, milieu, batchID, payments, addressCache, balanceCache)
+}
+
+func releaseBatch(ctx context.Context, milieu *core.Milieu, batchID int) {
+	// A run or resume in progress could be sending the same recipients
+	if !runMutex.TryLock() {
+		milieu.Info(fmt.Sprintf("A payout run is in progress, not releasing batch %v", batchID))
+		return
+	}
+	defer runMutex.Unlock()
+	if !isLeader(milieu) {
+		return
+	}
+	recipients, err := store.GetBatchRecipients(ctx, batchID, sql.RecipientQueued)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return
+	}
+	milieu.Info(fmt.Sprintf("Releasing %v queued recipients in batch %v", len(recipients), batchID))
+	for _, recipient := range recipients {
+		if err = releaseBatchRecipient(ctx, milieu, recipient, "Released by operator"); err != nil {
+			milieu.CaptureException(err)
+			milieu.Info(err.Error())
+		}
+	}
+	if err = store.RefreshBatchAmounts(ctx, batchID); err != nil {
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+	}
+}
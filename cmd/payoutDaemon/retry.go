@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/metrics"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"time"
+)
+
+/* Retrying wallet sends
+
+A failed wallet send is tried again up to --wallet-send-retries times with an exponential backoff, depending on the
+	error (see the wallet package):
+	1. It never reached the wallet, nothing can have been sent, the chunk is sent again as is.
+	2. Transient, a timeout or the wallet dropping the call, it may still have gone through.  Before the retry the
+		wallet's history is searched for the chunk's payment IDs, with the same matching recovery uses:
+		a. Exactly one match, the send went ahead, it is recorded from the wallet transaction and not sent again.
+		b. No match, nothing left the wallet for it, it is sent again.
+		c. More than one match, we can't tell, it is neither recorded nor sent again and recovery flags it for a human.
+	3. Definitive, the wallet turned it down, or an error we can't place, it is never retried.
+Whatever isn't settled once the retries run out, or a shutdown stops them, stays submitted for recoverPendingPayouts,
+	exactly as a failed send always has.  Anything already found in the wallet is still recorded.
+*/
+
+var walletRetry = wallet.RetryPolicy{Retries: 3, Delay: 2 * time.Second, MaxDelay: 30 * time.Second}
+
+// walletTimeout limits a single wallet send, 0 for no limit
+var walletTimeout = 2 * time.Minute
+
+// sendWithRetry hands the payments to the wallet, see above.  The recipients must already be flagged as submitted.
+// The sends and wallet checks run on ctx with its cancellation removed, ctx being done only stops further retries.
+// A partial result is returned without an error, the recipients missing from it are left submitted.
+func sendWithRetry(ctx context.Context, milieu *core.Milieu, batchID int, payments []*tari_generated.PaymentRecipient, addressCache map[string]uint64) (*tari_generated.TransferResponse, error) {
+	bookkeeping := context.WithoutCancel(ctx)
+	resp := &tari_generated.TransferResponse{}
+	remaining := payments
+	// giveUp leaves the remaining recipients submitted, whatever was found in the wallet still gets recorded
+	giveUp := func(err error) (*tari_generated.TransferResponse, error) {
+		if len(resp.Results) == 0 {
+			return nil, err
+		}
+		err = fmt.Errorf("batch %v: %v recipients were found in the wallet, the other %v stay submitted for recovery: %w", batchID, len(resp.Results), len(remaining), err)
+		milieu.CaptureException(err)
+		milieu.Info(err.Error())
+		return resp, nil
+	}
+	checkWallet := false
+	for attempt := 0; ; attempt++ {
+		if checkWallet {
+			found, unsent, err := findSentPayments(bookkeeping, milieu, batchID, remaining, addressCache)
+			if err != nil {
+				return giveUp(fmt.Errorf("checking the wallet before retrying batch %v: %w", batchID, err))
+			}
+			resp.Results = append(resp.Results, found...)
+			remaining = unsent
+			if len(remaining) == 0 {
+				return resp, nil
+			}
+		}
+
+		sendResp, err := sendAttempt(bookkeeping, remaining)
+		if err == nil {
+			metrics.WalletSendsTotal.WithLabelValues("ok").Inc()
+			resp.Results = append(resp.Results, sendResp.GetResults()...)
+			return resp, nil
+		}
+		metrics.WalletSendsTotal.WithLabelValues("error").Inc()
+		switch {
+		case wallet.IsNotSent(err):
+			checkWallet = false
+		case wallet.IsTransient(err):
+			checkWallet = true
+		default:
+			if wallet.IsDefinitive(err) {
+				metrics.WalletSendRetriesTotal.WithLabelValues("rejected").Inc()
+			}
+			return giveUp(err)
+		}
+		if attempt >= walletRetry.Retries {
+			metrics.WalletSendRetriesTotal.WithLabelValues("exhausted").Inc()
+			return giveUp(err)
+		}
+		milieu.Warn(fmt.Sprintf("Wallet send for batch %v failed: %v, retrying %v/%v", batchID, err, attempt+1, walletRetry.Retries))
+		metrics.WalletSendRetriesTotal.WithLabelValues("retried").Inc()
+		if waitErr := walletRetry.Wait(ctx, attempt+1); waitErr != nil {
+			return giveUp(fmt.Errorf("%w, not retrying: %v", err, waitErr))
+		}
+	}
+}
+
+// sendAttempt is a single wallet send, limited to walletTimeout
+func sendAttempt(ctx context.Context, payments []*tari_generated.PaymentRecipient) (*tari_generated.TransferResponse, error) {
+	if walletTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, walletTimeout)
+		defer cancel()
+	}
+	return walletClient.SendTransactions(ctx, payments)
+}
+
+// findSentPayments looks in the wallet for any of the payments a failed send went ahead with anyway.  It returns a
+// result for each one found, and the payments that are safe to send again.
+func findSentPayments(ctx context.Context, milieu *core.Milieu, batchID int, payments []*tari_generated.PaymentRecipient, addressCache map[string]uint64) ([]*tari_generated.TransferResult, []*tari_generated.PaymentRecipient, error) {
+	submitted, err := store.GetBatchRecipients(ctx, batchID, sql.RecipientSubmitted)
+	if err != nil {
+		return nil, nil, err
+	}
+	byBalance := make(map[uint64]sql.BatchRecipientSqlRow, len(submitted))
+	for _, recipient := range submitted {
+		byBalance[recipient.BalanceID] = recipient
+	}
+	pending := make([]sql.BatchRecipientSqlRow, 0, len(payments))
+	for _, payment := range payments {
+		recipient, ok := byBalance[addressCache[payment.Address]]
+		if !ok {
+			return nil, nil, fmt.Errorf("balance %v is no longer submitted in batch %v", addressCache[payment.Address], batchID)
+		}
+		pending = append(pending, recipient)
+	}
+
+	walletTransactions, err := walletClient.GetTransactionsInBlock(ctx, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches, err := matchPendingPayouts(ctx, pending, walletTransactions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make([]*tari_generated.TransferResult, 0)
+	unsent := make([]*tari_generated.PaymentRecipient, 0, len(payments))
+	for i, payment := range payments {
+		switch len(matches[i]) {
+		case 0:
+			unsent = append(unsent, payment)
+		case 1:
+			walletTx := matches[i][0]
+			milieu.Info(fmt.Sprintf("Wallet transaction %v found for balance %v in batch %v, not sending it again", walletTx.TxId, pending[i].BalanceID, batchID))
+			metrics.WalletSendRetriesTotal.WithLabelValues("found_in_wallet").Inc()
+			result := &tari_generated.TransferResult{Address: payment.Address, TransactionId: walletTx.TxId, IsSuccess: true}
+			if walletTx.IsCancelled {
+				result.IsSuccess = false
+				result.FailureMessage = "Transaction cancelled"
+			}
+			found = append(found, result)
+		default:
+			err = fmt.Errorf("balance %v in batch %v matches %v wallet transactions, not sending it again, recovery will flag it for review", pending[i].BalanceID, batchID, len(matches[i]))
+			milieu.CaptureException(err)
+			milieu.Error(err.Error())
+		}
+	}
+	return found, unsent, nil
+}
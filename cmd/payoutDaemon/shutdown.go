@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/robfig/cron/v3"
+	"net/http"
+	"time"
+)
+
+/* Shutting down
+
+SIGTERM or SIGINT cancels the context every run is started with, from then on nothing new is started: no run, no
+	batch, and no wallet send.  A send that is already under way is let finish along with its PSQL bookkeeping, see
+	sendPayments, so a shutdown can never leave coins sent without a record of them or a balance debited for a send
+	that didn't happen.  Recipients that were never sent stay queued and the summary says which batch to resume.
+A second signal kills payoutDaemon straight away, anything it leaves submitted is settled by recovery.go on the next
+	start.
+*/
+
+// adminShutdownTimeout is how long in-flight admin API requests get to finish
+const adminShutdownTimeout = 10 * time.Second
+
+// sessionStats is what this process has done, for the summary on the way out.  Only touched while holding runMutex.
+type sessionStats struct {
+	runs        int
+	batches     int
+	sent        uint64
+	failed      uint64
+	interrupted []int
+}
+
+var session sessionStats
+
+// stopReason says why ctx stopped a run early, for the logs
+func stopReason(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "run deadline exceeded"
+	}
+	return "shutting down"
+}
+
+// waitForShutdown blocks until ctx is cancelled, then waits for the run in progress to wind down and logs the summary
+func waitForShutdown(ctx context.Context, stop context.CancelFunc, milieu *core.Milieu, c *cron.Cron, admin *http.Server) {
+	<-ctx.Done()
+	// Let a second signal through to kill the process
+	stop()
+	milieu.Info("Shutdown requested, letting the batch in progress finish, signal again to exit immediately")
+
+	<-c.Stop().Done()
+	if admin != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			milieu.Info(fmt.Sprintf("Admin API didn't shut down cleanly: %v", err))
+		}
+		cancel()
+	}
+
+	// Runs started at boot or through the admin API aren't tracked by the cron
+	runMutex.Lock()
+	defer runMutex.Unlock()
+	logSessionSummary(milieu)
+}
+
+// logSessionSummary logs what this process has done, and anything it left for an operator.  Hold runMutex or make
+// sure no run can be in progress.
+func logSessionSummary(milieu *core.Milieu) {
+	milieu.Info(fmt.Sprintf("payoutDaemon exiting after %v runs and %v batches, %v sent, %v failed and credited back",
+		session.runs, session.batches, session.sent, session.failed))
+	for _, batchID := range session.interrupted {
+		milieu.Warn(fmt.Sprintf("Batch %v was interrupted with recipients still queued, send them with --resume-batch %v or credit them back with --release-batch %v", batchID, batchID, batchID))
+	}
+}
@@ -0,0 +1,137 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// Manage all payout approval SQL requests, no logic, just query and structs
+// Error management is lifted up and out despite access to sentry here.
+
+// A payout over the approval threshold waits in `payout_approvals` for an operator instead of being sent.  Once
+// approved it is paid by the next run, as long as the balance hasn't grown past the approved amount, and the approval
+// is consumed in the same PSQL txn that reserves the payout so it can only ever be used once.
+
+const (
+	// ApprovalPending is waiting on an operator
+	ApprovalPending = "pending"
+	// ApprovalApproved can be paid by the next run
+	ApprovalApproved = "approved"
+	// ApprovalRejected was turned down, the balance has been marked invalid
+	ApprovalRejected = "rejected"
+	// ApprovalPaid was used by the batch in BatchID
+	ApprovalPaid = "paid"
+	// ApprovalExpired was approved, but the balance grew past the amount before it was paid
+	ApprovalExpired = "expired"
+)
+
+var ErrApprovalNotApproved = errors.New("sql: payout approval is no longer approved")
+
+type PayoutApproval struct {
+	ID           uint64
+	BalanceID    uint64
+	Address      string
+	Amount       uint64
+	State        string
+	ResolvedBy   string
+	Note         string
+	BatchID      int
+	DateAdded    time.Time
+	DateResolved time.Time
+}
+
+const approvalColumns = "id, balance_id, address, amount, state, coalesce(resolved_by, ''), coalesce(note, ''), coalesce(batch_id, 0), date_added, coalesce(date_resolved, 'epoch')"
+
+func scanApprovals(rows pgx.Rows) ([]PayoutApproval, error) {
+	defer rows.Close()
+	result := make([]PayoutApproval, 0)
+	for rows.Next() {
+		var row PayoutApproval
+		if err := rows.Scan(&row.ID, &row.BalanceID, &row.Address, &row.Amount, &row.State, &row.ResolvedBy, &row.Note, &row.BatchID,
+			&row.DateAdded, &row.DateResolved); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// CreateApproval asks for the payout to be approved, it returns false if the balance already has one in flight
+func CreateApproval(ctx context.Context, milieu *core.Milieu, balanceID uint64, address string, amount uint64) (bool, error) {
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "insert into payout_approvals (balance_id, address, amount) values ($1, $2, $3) on conflict (balance_id) where state in ('pending', 'approved') do nothing", balanceID, address, amount)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetApproval returns a single approval, ErrNotFound if there isn't one
+func GetApproval(ctx context.Context, milieu *core.Milieu, approvalID uint64) (PayoutApproval, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+approvalColumns+" from payout_approvals where id = $1", approvalID)
+	if err != nil {
+		return PayoutApproval{}, err
+	}
+	result, err := scanApprovals(rows)
+	if err != nil {
+		return PayoutApproval{}, err
+	}
+	if len(result) == 0 {
+		return PayoutApproval{}, ErrNotFound
+	}
+	return result[0], nil
+}
+
+// GetApprovals returns every approval in the given state, oldest first
+func GetApprovals(ctx context.Context, milieu *core.Milieu, state string) ([]PayoutApproval, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+approvalColumns+" from payout_approvals where state = $1 order by id asc", state)
+	if err != nil {
+		return nil, err
+	}
+	return scanApprovals(rows)
+}
+
+// GetOpenApprovals returns every pending or approved approval, there is at most one per balance
+func GetOpenApprovals(ctx context.Context, milieu *core.Milieu) ([]PayoutApproval, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+approvalColumns+" from payout_approvals where state in ($1, $2) order by id asc", ApprovalPending, ApprovalApproved)
+	if err != nil {
+		return nil, err
+	}
+	return scanApprovals(rows)
+}
+
+// ResolveApproval moves a pending approval to approved or rejected, it returns false if it wasn't pending
+func ResolveApproval(ctx context.Context, milieu *core.Milieu, approvalID uint64, state string, resolvedBy string, note string) (bool, error) {
+	if state != ApprovalApproved && state != ApprovalRejected {
+		return false, errors.New("sql: an approval can only be resolved as approved or rejected")
+	}
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "update payout_approvals set state = $1, resolved_by = $2, note = nullif($3, ''), date_resolved = now() where id = $4 and state = $5", state, resolvedBy, note, approvalID, ApprovalPending)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ExpireApproval retires an approved approval that can no longer be used, it returns false if it wasn't approved
+func ExpireApproval(ctx context.Context, milieu *core.Milieu, approvalID uint64) (bool, error) {
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "update payout_approvals set state = $1 where id = $2 and state = $3", ApprovalExpired, approvalID, ApprovalApproved)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ConsumeApproval marks an approval as paid by the batch, it fails with ErrApprovalNotApproved if it isn't approved
+// any more, which must roll back the reservation
+func ConsumeApproval(ctx context.Context, txn pgx.Tx, approvalID uint64, batchID int) error {
+	tag, err := txn.Exec(ctx, "update payout_approvals set state = $1, batch_id = $2 where id = $3 and state = $4", ApprovalPaid, batchID, approvalID, ApprovalApproved)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrApprovalNotApproved
+	}
+	return nil
+}
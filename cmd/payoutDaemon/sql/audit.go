@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"context"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"time"
+)
+
+// Manage all audit log SQL requests, no logic, just query and structs
+// Error management is lifted up and out despite access to sentry here.
+
+// The audit log is append only, every change made to money or payout state by something other than a regular payout
+// run (reconcile fixes, operator actions) gets a row saying who did what to what.
+
+type AuditEntry struct {
+	ID        uint64
+	RunID     string
+	Actor     string
+	Action    string
+	Subject   string
+	Detail    string
+	DateAdded time.Time
+}
+
+// CreateAuditEntry appends to the audit log, RunID groups the entries written by a single run and can be empty
+func CreateAuditEntry(ctx context.Context, milieu *core.Milieu, entry AuditEntry) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "insert into audit_log (run_id, actor, action, subject, detail) values (nullif($1, ''), $2, $3, $4, $5)", entry.RunID, entry.Actor, entry.Action, entry.Subject, entry.Detail)
+	return err
+}
+
+// GetAuditEntries returns the most recent audit entries, newest first
+func GetAuditEntries(ctx context.Context, milieu *core.Milieu, limit int) ([]AuditEntry, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, coalesce(run_id, ''), actor, action, subject, coalesce(detail, ''), date_added from audit_log order by id desc limit $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]AuditEntry, 0)
+	for rows.Next() {
+		var row AuditEntry
+		if err = rows.Scan(&row.ID, &row.RunID, &row.Actor, &row.Action, &row.Subject, &row.Detail, &row.DateAdded); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
@@ -20,9 +20,10 @@ type BalanceSqlRow struct {
 	Valid                bool
 	Address              string
 	PayoutMinimum        uint64
+	InvalidReason        string
 }
 
-func GetAllBalances(milieu *core.Milieu, balancesSelectOrder int) ([]BalanceSqlRow, error) {
+func GetAllBalances(ctx context.Context, milieu *core.Milieu, balancesSelectOrder int) ([]BalanceSqlRow, error) {
 	orderByStr := ""
 	switch balancesSelectOrder {
 	case 1:
@@ -32,23 +33,21 @@ func GetAllBalances(milieu *core.Milieu, balancesSelectOrder int) ([]BalanceSqlR
 		orderByStr = " order by balance asc"
 		break
 	}
-	rows, err := milieu.GetRawPGXPool().Query(context.Background(), "select id, date_added, date_balance_increased, date_last_updated, balance, valid, address, payout_minimum from balances"+orderByStr)
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, date_added, date_balance_increased, date_last_updated, balance, valid, address, payout_minimum, coalesce(invalid_reason, '') from balances"+orderByStr)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	result := make([]BalanceSqlRow, 0)
 	for rows.Next() {
 		var id, balance, payoutMinimum uint64
 		var valid bool
-		var address string
+		var address, invalidReason string
 		var dateAdded, dateBalanceIncreased, dateLastUpdated time.Time
 		if err = rows.Scan(
-			&id, &dateAdded, &dateBalanceIncreased, &dateLastUpdated, &balance, &valid, &address, &payoutMinimum,
+			&id, &dateAdded, &dateBalanceIncreased, &dateLastUpdated, &balance, &valid, &address, &payoutMinimum, &invalidReason,
 		); err != nil {
-			// If we can't decode a row, we need to report it, but continue, lift it to Sentry though.
-			milieu.Info(err.Error())
-			milieu.CaptureException(err)
-			continue
+			return nil, err
 		}
 		result = append(result, BalanceSqlRow{
 			ID:                   id,
@@ -59,30 +58,59 @@ func GetAllBalances(milieu *core.Milieu, balancesSelectOrder int) ([]BalanceSqlR
 			Valid:                valid,
 			Address:              address,
 			PayoutMinimum:        payoutMinimum,
+			InvalidReason:        invalidReason,
 		})
 	}
-	return result, nil
+	return result, rows.Err()
 }
 
-func GetBalanceIDByAddress(milieu *core.Milieu, address string) (uint64, error) {
-	row := milieu.GetRawPGXPool().QueryRow(context.Background(), "select id from balances where address = $1", address)
+func GetBalanceIDByAddress(ctx context.Context, milieu *core.Milieu, address string) (uint64, error) {
+	row := milieu.GetRawPGXPool().QueryRow(ctx, "select id from balances where address = $1", address)
 	if row == nil {
 		return 0, errors.New("balance not found")
 	}
 	var id uint64
 	err := row.Scan(&id)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
 		return 0, err
 	}
 	return id, nil
 }
 
-func DecreaseBalance(txn pgx.Tx, balanceID uint64, amount uint64) error {
-	_, err := txn.Exec(context.Background(), "update balances set balance = balance - $1, date_last_updated = now() where id = $2", amount, balanceID)
+// GetOrCreateBalance returns the ID of the balance for the address, creating an empty one if it doesn't exist yet
+func GetOrCreateBalance(ctx context.Context, txn pgx.Tx, address string) (uint64, error) {
+	var id uint64
+	err := txn.QueryRow(ctx, "insert into balances (address) values ($1) on conflict (address) do update set address = excluded.address returning id", address).Scan(&id)
+	return id, err
+}
+
+// MarkBalanceInvalid stops a balance from being paid out, reason is kept for whoever has to look at it
+func MarkBalanceInvalid(ctx context.Context, milieu *core.Milieu, balanceID uint64, reason string) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update balances set valid = false, invalid_reason = $1, date_last_updated = now() where id = $2", reason, balanceID)
 	return err
 }
 
-func IncreaseBalance(txn pgx.Tx, balanceID uint64, amount uint64) error {
-	_, err := txn.Exec(context.Background(), "update balances set balance = balance + $1, date_last_updated = now(), date_balance_increased = now() where id = $2", amount, balanceID)
+// MarkBalanceValid puts a balance back into the payout rotation, clearing the reason it was taken out
+func MarkBalanceValid(ctx context.Context, milieu *core.Milieu, balanceID uint64) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update balances set valid = true, invalid_reason = null, date_last_updated = now() where id = $1", balanceID)
 	return err
 }
+
+// SetPayoutMinimum sets the balance a row has to reach before it is paid out without a bypass
+func SetPayoutMinimum(ctx context.Context, milieu *core.Milieu, balanceID uint64, payoutMinimum uint64) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update balances set payout_minimum = $1, date_last_updated = now() where id = $2", payoutMinimum, balanceID)
+	return err
+}
+
+// DecreaseBalance debits the balance, recording a ledger entry of entryType against reference
+func DecreaseBalance(ctx context.Context, txn pgx.Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	return changeBalance(ctx, txn, balanceID, -int64(amount), entryType, reference)
+}
+
+// IncreaseBalance credits the balance, recording a ledger entry of entryType against reference
+func IncreaseBalance(ctx context.Context, txn pgx.Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	return changeBalance(ctx, txn, balanceID, int64(amount), entryType, reference)
+}
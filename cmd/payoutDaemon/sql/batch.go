@@ -4,14 +4,25 @@ import (
 	"context"
 	"errors"
 	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4"
+	"time"
 )
 
 // Manage all Batch related SQL requests, no logic, just query and structs
 // Error management is lifted up and out despite access to sentry here.
 
+type BatchSqlRow struct {
+	ID            int
+	Count         int
+	Amount        uint64
+	DateAdded     time.Time
+	AmountSuccess uint64
+	AmountFail    uint64
+}
+
 // CreateNewBatch takes the transaction account and amount, and returns the ID for the batch for fkey work
-func CreateNewBatch(milieu *core.Milieu, txCount int, amount uint64) (int, error) {
-	row := milieu.GetRawPGXPool().QueryRow(context.Background(), "insert into payment_batch (count, amount) values ($1, $2) returning id", txCount, amount)
+func CreateNewBatch(ctx context.Context, milieu *core.Milieu, txCount int, amount uint64) (int, error) {
+	row := milieu.GetRawPGXPool().QueryRow(ctx, "insert into payment_batch (count, amount) values ($1, $2) returning id", txCount, amount)
 	if row == nil {
 		return 0, errors.New("unable to create new batch")
 	}
@@ -24,7 +35,41 @@ func CreateNewBatch(milieu *core.Milieu, txCount int, amount uint64) (int, error
 }
 
 // UpdateBatchAmounts sets the amounts success/failed
-func UpdateBatchAmounts(milieu *core.Milieu, batchID int, successAmount uint64, failedAmount uint64) error {
-	_, err := milieu.GetRawPGXPool().Exec(context.Background(), "update payment_batch set amount_success = $1, amount_fail = $2 where id = $3", successAmount, failedAmount, batchID)
+func UpdateBatchAmounts(ctx context.Context, milieu *core.Milieu, batchID int, successAmount uint64, failedAmount uint64) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update payment_batch set amount_success = $1, amount_fail = $2 where id = $3", successAmount, failedAmount, batchID)
+	return err
+}
+
+// RefreshBatchAmounts recomputes the amounts success/failed from the batch recipients, so a resumed batch ends up with
+// the same totals as one that ran start to finish
+func RefreshBatchAmounts(ctx context.Context, milieu *core.Milieu, batchID int) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, `update payment_batch set
+		amount_success = (select coalesce(sum(amount), 0) from payment_batch_recipients where batch_id = $1 and state = $2),
+		amount_fail = (select coalesce(sum(amount), 0) from payment_batch_recipients where batch_id = $1 and state = $3)
+		where id = $1`, batchID, RecipientSucceeded, RecipientFailed)
 	return err
 }
+
+// GetBatch returns the batch with the given ID, ErrNotFound if there isn't one
+func GetBatch(ctx context.Context, milieu *core.Milieu, batchID int) (BatchSqlRow, error) {
+	var row BatchSqlRow
+	err := milieu.GetRawPGXPool().QueryRow(ctx, "select id, count, amount, date_added, amount_success, amount_fail from payment_batch where id = $1", batchID).Scan(
+		&row.ID, &row.Count, &row.Amount, &row.DateAdded, &row.AmountSuccess, &row.AmountFail,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// GetLatestBatch returns the most recent batch, ErrNotFound if there has never been one
+func GetLatestBatch(ctx context.Context, milieu *core.Milieu) (BatchSqlRow, error) {
+	var row BatchSqlRow
+	err := milieu.GetRawPGXPool().QueryRow(ctx, "select id, count, amount, date_added, amount_success, amount_fail from payment_batch order by id desc limit 1").Scan(
+		&row.ID, &row.Count, &row.Amount, &row.DateAdded, &row.AmountSuccess, &row.AmountFail,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
@@ -0,0 +1,152 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// Manage all per-recipient batch SQL requests, no logic, just query and structs
+// Error management is lifted up and out despite access to sentry here.
+
+// A recipient row is created in the same PSQL txn that debits the balance, before the wallet is ever contacted, so the
+// balance stays reserved for as long as the row is queued or submitted.  Rows are never deleted, the full recipient
+// list of every batch is kept along with where each recipient ended up.
+
+const (
+	// RecipientQueued has been reserved but not handed to the wallet, safe to send on resume
+	RecipientQueued = "queued"
+	// RecipientSubmitted has been handed to the wallet, but the result was never recorded
+	RecipientSubmitted = "submitted"
+	// RecipientSucceeded was sent by the wallet
+	RecipientSucceeded = "succeeded"
+	// RecipientFailed was rejected by the wallet, and the reservation credited back
+	RecipientFailed = "failed"
+	// RecipientSkipped was never sent, and the reservation credited back
+	RecipientSkipped = "skipped"
+)
+
+// ErrRecipientMoved is a recipient that wasn't in the state the caller expected, someone else has already moved it on
+var ErrRecipientMoved = errors.New("sql: batch recipient is no longer in the expected state")
+
+type BatchRecipientSqlRow struct {
+	ID          uint64
+	BatchID     int
+	BalanceID   uint64
+	Address     string
+	Amount      uint64
+	SendAmount  uint64
+	FeePerGram  uint64
+	State       string
+	TxID        uint64
+	Error       string
+	DateAdded   time.Time
+	DateUpdated time.Time
+	// DateSubmitted is when it was handed to the wallet, zero while it is queued
+	DateSubmitted time.Time
+}
+
+// BalanceSpend is how much of a balance has been handed to the wallet over a window, fees included
+type BalanceSpend struct {
+	BalanceID uint64
+	Amount    uint64
+}
+
+// CreateBatchRecipient queues a recipient against the batch, amount is what has been debited from the balance,
+// sendAmount is what is going to be handed to the wallet, the difference being the fee at feePerGram.
+func CreateBatchRecipient(ctx context.Context, txn pgx.Tx, batchID int, balanceID uint64, address string, amount uint64, sendAmount uint64, feePerGram uint64) error {
+	_, err := txn.Exec(ctx, "insert into payment_batch_recipients (batch_id, balance_id, address, amount, send_amount, fee_per_gram, state) values ($1, $2, $3, $4, $5, $6, $7)", batchID, balanceID, address, amount, sendAmount, feePerGram, RecipientQueued)
+	return err
+}
+
+// SetBatchRecipientsSubmitted flags the recipients as handed to the wallet, this must be done before the wallet call.
+// Only queued recipients are moved, the count returned is how many were, anything short of all of them means another
+// instance got to them first.
+func SetBatchRecipientsSubmitted(ctx context.Context, milieu *core.Milieu, batchID int, balanceIDs []uint64) (int64, error) {
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "update payment_batch_recipients set state = $1, date_updated = now(), date_submitted = now() where batch_id = $2 and balance_id = any($3) and state = $4", RecipientSubmitted, batchID, balanceIDs, RecipientQueued)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ResolveBatchRecipient moves a recipient from the state it is expected to be in to its final state, along with the
+// wallet TxID and error if there is one.  It fails with ErrRecipientMoved if the recipient wasn't in the from state, as
+// the row is locked until the PSQL txn ends this makes it the guard against resolving, and crediting, it twice.
+func ResolveBatchRecipient(ctx context.Context, txn pgx.Tx, batchID int, balanceID uint64, from string, state string, txID uint64, errorString string) error {
+	tag, err := txn.Exec(ctx, "update payment_batch_recipients set state = $1, tx_id = $2, error = $3, date_updated = now() where batch_id = $4 and balance_id = $5 and state = $6", state, txID, errorString, batchID, balanceID, from)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRecipientMoved
+	}
+	return nil
+}
+
+func scanBatchRecipients(rows pgx.Rows) ([]BatchRecipientSqlRow, error) {
+	defer rows.Close()
+	result := make([]BatchRecipientSqlRow, 0)
+	for rows.Next() {
+		var row BatchRecipientSqlRow
+		var submitted *time.Time
+		if err := rows.Scan(&row.ID, &row.BatchID, &row.BalanceID, &row.Address, &row.Amount, &row.SendAmount, &row.FeePerGram, &row.State,
+			&row.TxID, &row.Error, &row.DateAdded, &row.DateUpdated, &submitted); err != nil {
+			return nil, err
+		}
+		if submitted != nil {
+			row.DateSubmitted = *submitted
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// GetBatchRecipients returns every recipient in the batch with the given state, in the order they were queued
+func GetBatchRecipients(ctx context.Context, milieu *core.Milieu, batchID int, state string) ([]BatchRecipientSqlRow, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, batch_id, balance_id, address, amount, send_amount, fee_per_gram, state, coalesce(tx_id, 0), coalesce(error, ''), date_added, date_updated, date_submitted from payment_batch_recipients where batch_id = $1 and state = $2 order by id asc", batchID, state)
+	if err != nil {
+		return nil, err
+	}
+	return scanBatchRecipients(rows)
+}
+
+// GetAllBatchRecipients returns every recipient in the batch whatever their state, in the order they were queued
+func GetAllBatchRecipients(ctx context.Context, milieu *core.Milieu, batchID int) ([]BatchRecipientSqlRow, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, batch_id, balance_id, address, amount, send_amount, fee_per_gram, state, coalesce(tx_id, 0), coalesce(error, ''), date_added, date_updated, date_submitted from payment_batch_recipients where batch_id = $1 order by id asc", batchID)
+	if err != nil {
+		return nil, err
+	}
+	return scanBatchRecipients(rows)
+}
+
+// GetAllBatchRecipientsByState returns every recipient across all batches with the given state, oldest first
+func GetAllBatchRecipientsByState(ctx context.Context, milieu *core.Milieu, state string) ([]BatchRecipientSqlRow, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, batch_id, balance_id, address, amount, send_amount, fee_per_gram, state, coalesce(tx_id, 0), coalesce(error, ''), date_added, date_updated, date_submitted from payment_batch_recipients where state = $1 order by id asc", state)
+	if err != nil {
+		return nil, err
+	}
+	return scanBatchRecipients(rows)
+}
+
+// GetSpendSince sums every recipient submitted to or sent by the wallet since the given time, by balance.  Submitted
+// recipients are counted as they may well have been sent, failed and skipped ones never left.  They are placed in the
+// window by when they were handed to the wallet, date_updated moves again once the send is resolved.
+func GetSpendSince(ctx context.Context, milieu *core.Milieu, since time.Time) ([]BalanceSpend, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select balance_id, sum(amount) from payment_batch_recipients where state in ($1, $2) and date_submitted >= $3 group by balance_id", RecipientSubmitted, RecipientSucceeded, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]BalanceSpend, 0)
+	for rows.Next() {
+		var row BalanceSpend
+		if err = rows.Scan(&row.BalanceID, &row.Amount); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
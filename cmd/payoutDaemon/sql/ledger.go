@@ -0,0 +1,141 @@
+package sql
+
+import (
+	"context"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// Manage all balance ledger SQL requests, no logic, just query and structs
+// Error management is lifted up and out despite access to sentry here.
+
+// `balance_ledger` is append only, every change to a balance writes a row in the same statement as the change itself
+// (see changeBalance), so summing a balance's entries always gives the balance.  Debits are negative.
+
+const (
+	// LedgerOpening is the balance a row held when the ledger was created
+	LedgerOpening = "opening"
+	// LedgerCredit is money owed to the address, a faucet claim or anything else adding to it
+	LedgerCredit = "credit"
+	// LedgerPayoutDebit is the part of a payout sent to the address
+	LedgerPayoutDebit = "payout_debit"
+	// LedgerFee is the part of a payout that goes to the network
+	LedgerFee = "fee"
+	// LedgerRepay is a payout credited back, it failed, was never sent, or was dropped by the wallet
+	LedgerRepay = "repay"
+	// LedgerManualAdjustment is an operator correction
+	LedgerManualAdjustment = "manual_adjustment"
+)
+
+type LedgerEntry struct {
+	ID           uint64
+	BalanceID    uint64
+	EntryType    string
+	Amount       int64
+	BalanceAfter int64
+	Reference    string
+	DateAdded    time.Time
+}
+
+// LedgerDrift is a balance that doesn't match the sum of its ledger entries
+type LedgerDrift struct {
+	BalanceID   uint64
+	Address     string
+	Balance     int64
+	LedgerTotal int64
+}
+
+// BalanceCredit is how much has been credited to a balance over a window
+type BalanceCredit struct {
+	BalanceID uint64
+	Amount    uint64
+}
+
+// changeBalance moves a balance by delta and writes the ledger entry for it in a single statement, it fails with
+// ErrNotFound if there is no such balance
+func changeBalance(ctx context.Context, txn pgx.Tx, balanceID uint64, delta int64, entryType string, reference string) error {
+	setIncreased := ""
+	if delta > 0 {
+		setIncreased = ", date_balance_increased = now()"
+	}
+	tag, err := txn.Exec(ctx, `with updated as (
+	update balances set balance = balance + $1, date_last_updated = now()`+setIncreased+` where id = $2 returning id, balance
+)
+insert into balance_ledger (balance_id, entry_type, amount, balance_after, reference)
+select id, $3, $1, balance, nullif($4, '') from updated`, delta, balanceID, entryType, reference)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetLedgerEntries returns every ledger entry for the balance, oldest first
+func GetLedgerEntries(ctx context.Context, milieu *core.Milieu, balanceID uint64) ([]LedgerEntry, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, balance_id, entry_type, amount, balance_after, coalesce(reference, ''), date_added from balance_ledger where balance_id = $1 order by id asc", balanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]LedgerEntry, 0)
+	for rows.Next() {
+		var row LedgerEntry
+		if err = rows.Scan(&row.ID, &row.BalanceID, &row.EntryType, &row.Amount, &row.BalanceAfter, &row.Reference, &row.DateAdded); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// GetLedgerDrift recomputes every balance from its ledger entries and returns the ones that don't match
+func GetLedgerDrift(ctx context.Context, milieu *core.Milieu) ([]LedgerDrift, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, `select b.id, b.address, b.balance, coalesce(l.total, 0)
+from balances b
+         left join (select balance_id, sum(amount) as total from balance_ledger group by balance_id) l on l.balance_id = b.id
+where b.balance <> coalesce(l.total, 0)
+order by b.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]LedgerDrift, 0)
+	for rows.Next() {
+		var row LedgerDrift
+		if err = rows.Scan(&row.BalanceID, &row.Address, &row.Balance, &row.LedgerTotal); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// CreateLedgerAdjustment writes a manual adjustment without touching the balance, for bringing the ledger back in line
+// with a balance that was changed behind its back
+func CreateLedgerAdjustment(ctx context.Context, milieu *core.Milieu, balanceID uint64, amount int64, reference string) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "insert into balance_ledger (balance_id, entry_type, amount, balance_after, reference) select id, $1, $2, balance, nullif($3, '') from balances where id = $4",
+		LedgerManualAdjustment, amount, reference, balanceID)
+	return err
+}
+
+// GetCreditedSince sums the credits and positive manual adjustments made to each balance since the given time, repays
+// are left out as they are only money coming back
+func GetCreditedSince(ctx context.Context, milieu *core.Milieu, since time.Time) ([]BalanceCredit, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select balance_id, sum(amount) from balance_ledger where entry_type in ($1, $2) and amount > 0 and date_added >= $3 group by balance_id", LedgerCredit, LedgerManualAdjustment, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]BalanceCredit, 0)
+	for rows.Next() {
+		var row BalanceCredit
+		if err = rows.Scan(&row.BalanceID, &row.Amount); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,979 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store for tests and tools that shouldn't need a database.  It follows the same rules as
+// the schema (unique recipients per batch, upserting transactions, etc), writes made through a Tx are applied straight
+// away and undone on Rollback, there is no isolation between concurrent Tx's.
+
+type MemoryStore struct {
+	mu            sync.Mutex
+	nextBalanceID uint64
+	nextBatchID   int
+	nextRecipient uint64
+	balances      map[uint64]*BalanceSqlRow
+	batches       map[int]*BatchSqlRow
+	recipients    []*BatchRecipientSqlRow
+	transactions  map[uint64]*TransactionSqlRow
+	details       map[uint64]*TransactionDetail
+	audit         []AuditEntry
+	ledger        []LedgerEntry
+	reviews       []ReviewEntry
+	approvals     []PayoutApproval
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextBalanceID: 1,
+		nextBatchID:   1,
+		nextRecipient: 1,
+		balances:      make(map[uint64]*BalanceSqlRow),
+		batches:       make(map[int]*BatchSqlRow),
+		transactions:  make(map[uint64]*TransactionSqlRow),
+		details:       make(map[uint64]*TransactionDetail),
+	}
+}
+
+type memoryTx struct {
+	store *MemoryStore
+	undo  []func()
+	done  bool
+}
+
+func (m *memoryTx) Commit() error {
+	if m.done {
+		return errors.New("sql: transaction already closed")
+	}
+	m.done = true
+	m.undo = nil
+	return nil
+}
+
+func (m *memoryTx) Rollback() error {
+	if m.done {
+		return nil
+	}
+	m.done = true
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	for i := len(m.undo) - 1; i >= 0; i-- {
+		m.undo[i]()
+	}
+	m.undo = nil
+	return nil
+}
+
+func (s *MemoryStore) Begin(ctx context.Context) (Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &memoryTx{store: s}, nil
+}
+
+// memTx checks the Tx belongs to this store, the caller must hold s.mu
+func (s *MemoryStore) memTx(tx Tx) (*memoryTx, error) {
+	if memTx, ok := tx.(*memoryTx); ok && memTx.store == s && !memTx.done {
+		return memTx, nil
+	}
+	return nil, ErrForeignTx
+}
+
+// AddBalance seeds a balance row, the ID is assigned by the store and returned
+func (s *MemoryStore) AddBalance(row BalanceSqlRow) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row.ID = s.nextBalanceID
+	s.nextBalanceID += 1
+	if row.DateAdded.IsZero() {
+		row.DateAdded = time.Now()
+		row.DateBalanceIncreased = row.DateAdded
+		row.DateLastUpdated = row.DateAdded
+	}
+	s.balances[row.ID] = &row
+	if row.Balance != 0 {
+		s.appendLedger(row.ID, LedgerOpening, int64(row.Balance), int64(row.Balance), "opening balance")
+	}
+	return row.ID
+}
+
+func (s *MemoryStore) appendLedger(balanceID uint64, entryType string, amount int64, balanceAfter int64, reference string) {
+	s.ledger = append(s.ledger, LedgerEntry{
+		ID:           uint64(len(s.ledger) + 1),
+		BalanceID:    balanceID,
+		EntryType:    entryType,
+		Amount:       amount,
+		BalanceAfter: balanceAfter,
+		Reference:    reference,
+		DateAdded:    time.Now(),
+	})
+}
+
+// Balance returns a copy of the balance row
+func (s *MemoryStore) Balance(balanceID uint64) (BalanceSqlRow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.balances[balanceID]; ok {
+		return *row, true
+	}
+	return BalanceSqlRow{}, false
+}
+
+// Transactions returns a copy of every recorded transaction, in TxID order
+func (s *MemoryStore) Transactions() []TransactionSqlRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]TransactionSqlRow, 0, len(s.transactions))
+	for _, row := range s.transactions {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (s *MemoryStore) GetAllBalances(ctx context.Context, balancesSelectOrder int) ([]BalanceSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]BalanceSqlRow, 0, len(s.balances))
+	for _, row := range s.balances {
+		result = append(result, *row)
+	}
+	switch balancesSelectOrder {
+	case 1:
+		sort.Slice(result, func(i, j int) bool { return result[i].Balance > result[j].Balance })
+	case 2:
+		sort.Slice(result, func(i, j int) bool { return result[i].Balance < result[j].Balance })
+	default:
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetBalanceIDByAddress(ctx context.Context, address string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.balances {
+		if row.Address == address {
+			return row.ID, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (s *MemoryStore) GetOrCreateBalance(ctx context.Context, tx Tx, address string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range s.balances {
+		if row.Address == address {
+			return row.ID, nil
+		}
+	}
+	// Same defaults as the balances table
+	now := time.Now()
+	row := &BalanceSqlRow{
+		ID:                   s.nextBalanceID,
+		DateAdded:            now,
+		DateBalanceIncreased: now,
+		DateLastUpdated:      now,
+		Valid:                true,
+		Address:              address,
+		PayoutMinimum:        10000000,
+	}
+	s.nextBalanceID += 1
+	s.balances[row.ID] = row
+	memTx.undo = append(memTx.undo, func() { delete(s.balances, row.ID) })
+	return row.ID, nil
+}
+
+func (s *MemoryStore) MarkBalanceInvalid(ctx context.Context, balanceID uint64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.balances[balanceID]; ok {
+		row.Valid = false
+		row.InvalidReason = reason
+		row.DateLastUpdated = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkBalanceValid(ctx context.Context, balanceID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.balances[balanceID]; ok {
+		row.Valid = true
+		row.InvalidReason = ""
+		row.DateLastUpdated = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetPayoutMinimum(ctx context.Context, balanceID uint64, payoutMinimum uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.balances[balanceID]; ok {
+		row.PayoutMinimum = payoutMinimum
+		row.DateLastUpdated = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) changeBalance(tx Tx, balanceID uint64, delta int64, entryType string, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	row, ok := s.balances[balanceID]
+	if !ok {
+		return ErrNotFound
+	}
+	previous := *row
+	previousLedger := len(s.ledger)
+	row.Balance = uint64(int64(row.Balance) + delta)
+	row.DateLastUpdated = time.Now()
+	if delta > 0 {
+		row.DateBalanceIncreased = row.DateLastUpdated
+	}
+	s.appendLedger(balanceID, entryType, delta, int64(row.Balance), reference)
+	memTx.undo = append(memTx.undo, func() {
+		*row = previous
+		s.ledger = s.ledger[:previousLedger]
+	})
+	return nil
+}
+
+func (s *MemoryStore) DecreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	return s.changeBalance(tx, balanceID, -int64(amount), entryType, reference)
+}
+
+func (s *MemoryStore) IncreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	return s.changeBalance(tx, balanceID, int64(amount), entryType, reference)
+}
+
+func (s *MemoryStore) GetLedgerEntries(ctx context.Context, balanceID uint64) ([]LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]LedgerEntry, 0)
+	for _, entry := range s.ledger {
+		if entry.BalanceID == balanceID {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetLedgerDrift(ctx context.Context) ([]LedgerDrift, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[uint64]int64)
+	for _, entry := range s.ledger {
+		totals[entry.BalanceID] += entry.Amount
+	}
+	result := make([]LedgerDrift, 0)
+	for _, row := range s.balances {
+		if int64(row.Balance) != totals[row.ID] {
+			result = append(result, LedgerDrift{BalanceID: row.ID, Address: row.Address, Balance: int64(row.Balance), LedgerTotal: totals[row.ID]})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BalanceID < result[j].BalanceID })
+	return result, nil
+}
+
+func (s *MemoryStore) CreateLedgerAdjustment(ctx context.Context, balanceID uint64, amount int64, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.balances[balanceID]; ok {
+		s.appendLedger(balanceID, LedgerManualAdjustment, amount, int64(row.Balance), reference)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetCreditedSince(ctx context.Context, since time.Time) ([]BalanceCredit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byBalance := make(map[uint64]uint64)
+	order := make([]uint64, 0)
+	for _, entry := range s.ledger {
+		if (entry.EntryType != LedgerCredit && entry.EntryType != LedgerManualAdjustment) || entry.Amount <= 0 || entry.DateAdded.Before(since) {
+			continue
+		}
+		if _, ok := byBalance[entry.BalanceID]; !ok {
+			order = append(order, entry.BalanceID)
+		}
+		byBalance[entry.BalanceID] += uint64(entry.Amount)
+	}
+	result := make([]BalanceCredit, 0, len(order))
+	for _, balanceID := range order {
+		result = append(result, BalanceCredit{BalanceID: balanceID, Amount: byBalance[balanceID]})
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) CreateNewBatch(ctx context.Context, txCount int, amount uint64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextBatchID
+	s.nextBatchID += 1
+	s.batches[id] = &BatchSqlRow{ID: id, Count: txCount, Amount: amount, DateAdded: time.Now()}
+	return id, nil
+}
+
+func (s *MemoryStore) GetBatch(ctx context.Context, batchID int) (BatchSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.batches[batchID]; ok {
+		return *row, nil
+	}
+	return BatchSqlRow{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetLatestBatch(ctx context.Context) (BatchSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.batches[s.nextBatchID-1]; ok {
+		return *row, nil
+	}
+	return BatchSqlRow{}, ErrNotFound
+}
+
+func (s *MemoryStore) UpdateBatchAmounts(ctx context.Context, batchID int, successAmount uint64, failedAmount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.batches[batchID]; ok {
+		row.AmountSuccess = successAmount
+		row.AmountFail = failedAmount
+	}
+	return nil
+}
+
+func (s *MemoryStore) RefreshBatchAmounts(ctx context.Context, batchID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.batches[batchID]
+	if !ok {
+		return nil
+	}
+	row.AmountSuccess, row.AmountFail = 0, 0
+	for _, recipient := range s.recipients {
+		if recipient.BatchID != batchID {
+			continue
+		}
+		switch recipient.State {
+		case RecipientSucceeded:
+			row.AmountSuccess += recipient.Amount
+		case RecipientFailed:
+			row.AmountFail += recipient.Amount
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) findRecipient(batchID int, balanceID uint64) *BatchRecipientSqlRow {
+	for _, recipient := range s.recipients {
+		if recipient.BatchID == batchID && recipient.BalanceID == balanceID {
+			return recipient
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, address string, amount uint64, sendAmount uint64, feePerGram uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	if s.findRecipient(batchID, balanceID) != nil {
+		return fmt.Errorf("sql: recipient for balance %v already exists in batch %v", balanceID, batchID)
+	}
+	now := time.Now()
+	s.recipients = append(s.recipients, &BatchRecipientSqlRow{
+		ID:          s.nextRecipient,
+		BatchID:     batchID,
+		BalanceID:   balanceID,
+		Address:     address,
+		Amount:      amount,
+		SendAmount:  sendAmount,
+		FeePerGram:  feePerGram,
+		State:       RecipientQueued,
+		DateAdded:   now,
+		DateUpdated: now,
+	})
+	s.nextRecipient += 1
+	memTx.undo = append(memTx.undo, func() { s.recipients = s.recipients[:len(s.recipients)-1] })
+	return nil
+}
+
+func (s *MemoryStore) SetBatchRecipientsSubmitted(ctx context.Context, batchID int, balanceIDs []uint64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated int64
+	for _, balanceID := range balanceIDs {
+		if recipient := s.findRecipient(batchID, balanceID); recipient != nil && recipient.State == RecipientQueued {
+			recipient.State = RecipientSubmitted
+			recipient.DateUpdated = time.Now()
+			recipient.DateSubmitted = recipient.DateUpdated
+			updated += 1
+		}
+	}
+	return updated, nil
+}
+
+func (s *MemoryStore) ResolveBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, from string, state string, txID uint64, errorString string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	recipient := s.findRecipient(batchID, balanceID)
+	if recipient == nil || recipient.State != from {
+		return ErrRecipientMoved
+	}
+	previous := *recipient
+	recipient.State = state
+	recipient.TxID = txID
+	recipient.Error = errorString
+	recipient.DateUpdated = time.Now()
+	memTx.undo = append(memTx.undo, func() { *recipient = previous })
+	return nil
+}
+
+func (s *MemoryStore) GetBatchRecipients(ctx context.Context, batchID int, state string) ([]BatchRecipientSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]BatchRecipientSqlRow, 0)
+	for _, recipient := range s.recipients {
+		if recipient.BatchID == batchID && recipient.State == state {
+			result = append(result, *recipient)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetAllBatchRecipients(ctx context.Context, batchID int) ([]BatchRecipientSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]BatchRecipientSqlRow, 0)
+	for _, recipient := range s.recipients {
+		if recipient.BatchID == batchID {
+			result = append(result, *recipient)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetAllBatchRecipientsByState(ctx context.Context, state string) ([]BatchRecipientSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]BatchRecipientSqlRow, 0)
+	for _, recipient := range s.recipients {
+		if recipient.State == state {
+			result = append(result, *recipient)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetSpendSince(ctx context.Context, since time.Time) ([]BalanceSpend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byBalance := make(map[uint64]uint64)
+	order := make([]uint64, 0)
+	for _, recipient := range s.recipients {
+		if (recipient.State != RecipientSubmitted && recipient.State != RecipientSucceeded) || recipient.DateSubmitted.Before(since) {
+			continue
+		}
+		if _, ok := byBalance[recipient.BalanceID]; !ok {
+			order = append(order, recipient.BalanceID)
+		}
+		byBalance[recipient.BalanceID] += recipient.Amount
+	}
+	result := make([]BalanceSpend, 0, len(order))
+	for _, balanceID := range order {
+		result = append(result, BalanceSpend{BalanceID: balanceID, Amount: byBalance[balanceID]})
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) CreateNewTransaction(ctx context.Context, tx Tx, txID uint64, success bool, errorString string, balanceID uint64, batchID int, amount uint64, fee uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	previous, existed := s.transactions[txID]
+	s.transactions[txID] = &TransactionSqlRow{
+		ID:        txID,
+		Success:   success,
+		Error:     errorString,
+		BalanceID: balanceID,
+		BatchID:   batchID,
+		Amount:    amount,
+		Fee:       fee,
+	}
+	memTx.undo = append(memTx.undo, func() {
+		if existed {
+			s.transactions[txID] = previous
+		} else {
+			delete(s.transactions, txID)
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStore) FailTransaction(ctx context.Context, tx Tx, txID uint64, errorString string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return false, err
+	}
+	row, ok := s.transactions[txID]
+	if !ok || !row.Success {
+		return false, nil
+	}
+	previous := *row
+	row.Success = false
+	row.Error = errorString
+	memTx.undo = append(memTx.undo, func() { *row = previous })
+	return true, nil
+}
+
+func (s *MemoryStore) SetTransactionFee(ctx context.Context, txID uint64, fee uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.transactions[txID]; ok {
+		row.Fee = fee
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetTransaction(ctx context.Context, txID uint64) (TransactionSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.transactions[txID]; ok {
+		return *row, nil
+	}
+	return TransactionSqlRow{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetAllTransactions(ctx context.Context) ([]TransactionSqlRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]TransactionSqlRow, 0, len(s.transactions))
+	for _, row := range s.transactions {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (s *MemoryStore) GetSuccessfulTransactionIDs(ctx context.Context) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]uint64, 0)
+	for _, row := range s.transactions {
+		if row.Success {
+			result = append(result, row.ID)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+func (s *MemoryStore) GetRecordedTransactionIDs(ctx context.Context, txIDs []uint64) (map[uint64]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[uint64]bool)
+	for _, txID := range txIDs {
+		if _, ok := s.transactions[txID]; ok {
+			result[txID] = true
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) CreateTransactionDetail(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.details[txnDetail.TxId]; ok {
+		return fmt.Errorf("sql: transaction detail %v already exists", txnDetail.TxId)
+	}
+	s.details[txnDetail.TxId] = &TransactionDetail{
+		ID:            txnDetail.TxId,
+		Status:        uint64(txnDetail.Status.Number()),
+		Amount:        txnDetail.Amount,
+		Fee:           txnDetail.Fee,
+		IsCancelled:   txnDetail.IsCancelled,
+		ExcessSig:     txnDetail.ExcessSig,
+		Timestamp:     time.Unix(int64(txnDetail.Timestamp), 0),
+		RawPaymentID:  txnDetail.RawPaymentId,
+		MinedAtHeight: txnDetail.MinedInBlockHeight,
+		UserPaymentID: txnDetail.UserPaymentId,
+		DestAddress:   txnDetail.DestAddress,
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateMinedAtHeight(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.details[txnDetail.TxId]; ok {
+		row.MinedAtHeight = txnDetail.MinedInBlockHeight
+	}
+	return nil
+}
+
+func (s *MemoryStore) TransactionDetailExists(ctx context.Context, txID uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.details[txID]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetUnminedTransactionDetailIDs(ctx context.Context) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]uint64, 0)
+	for _, row := range s.details {
+		if row.MinedAtHeight == 0 {
+			result = append(result, row.ID)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+func (s *MemoryStore) GetUncheckedTransactionDetails(ctx context.Context) ([]TransactionDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]TransactionDetail, 0)
+	for _, row := range s.details {
+		if !row.Rechecked {
+			result = append(result, *row)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+	return result, nil
+}
+
+func (s *MemoryStore) UpdateTransactionDetailStatus(ctx context.Context, txID uint64, status uint64, isCancelled bool, minedAtHeight uint64, rechecked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row, ok := s.details[txID]; ok {
+		row.Status = status
+		row.IsCancelled = isCancelled
+		row.MinedAtHeight = minedAtHeight
+		row.Rechecked = rechecked
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetTransactionDetailRepaid(ctx context.Context, tx Tx, txID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	row, ok := s.details[txID]
+	if !ok {
+		return nil
+	}
+	previous := row.Repaid
+	row.Repaid = true
+	memTx.undo = append(memTx.undo, func() { row.Repaid = previous })
+	return nil
+}
+
+func (s *MemoryStore) GetAllTransactionDetails(ctx context.Context) ([]TransactionDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]TransactionDetail, 0, len(s.details))
+	for _, row := range s.details {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+	return result, nil
+}
+
+func (s *MemoryStore) CreateAuditEntry(ctx context.Context, entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.ID = uint64(len(s.audit) + 1)
+	entry.DateAdded = time.Now()
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *MemoryStore) GetAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]AuditEntry, 0, limit)
+	for i := len(s.audit) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, s.audit[i])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) CreateTransactionDetails(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	var inserted int64
+	for _, txnDetail := range txnDetails {
+		if exists, _ := s.TransactionDetailExists(ctx, txnDetail.TxId); exists {
+			continue
+		}
+		if err := s.CreateTransactionDetail(ctx, txnDetail); err != nil {
+			return inserted, err
+		}
+		inserted += 1
+	}
+	return inserted, nil
+}
+
+func (s *MemoryStore) UpdateMinedAtHeights(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated int64
+	for _, txnDetail := range txnDetails {
+		if row, ok := s.details[txnDetail.TxId]; ok && row.MinedAtHeight == 0 && txnDetail.MinedInBlockHeight > 0 {
+			row.MinedAtHeight = txnDetail.MinedInBlockHeight
+			updated += 1
+		}
+	}
+	return updated, nil
+}
+
+func (s *MemoryStore) GetTransactionIDsWithoutDetail(ctx context.Context) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]uint64, 0)
+	for _, row := range s.transactions {
+		if _, ok := s.details[row.ID]; row.Success && !ok {
+			result = append(result, row.ID)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+func (s *MemoryStore) GetPayoutHistory(ctx context.Context) ([]PayoutHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byBalance := make(map[uint64]*PayoutHistory)
+	for _, row := range s.transactions {
+		if !row.Success {
+			continue
+		}
+		history, ok := byBalance[row.BalanceID]
+		if !ok {
+			history = &PayoutHistory{BalanceID: row.BalanceID}
+			byBalance[row.BalanceID] = history
+		}
+		history.Count += 1
+		if row.Amount > history.Largest {
+			history.Largest = row.Amount
+		}
+	}
+	result := make([]PayoutHistory, 0, len(byBalance))
+	for _, history := range byBalance {
+		result = append(result, *history)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BalanceID < result[j].BalanceID })
+	return result, nil
+}
+
+func (s *MemoryStore) CreateReview(ctx context.Context, balanceID uint64, address string, balance uint64, reasons string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, review := range s.reviews {
+		if review.BalanceID == balanceID && review.State == ReviewPending {
+			return false, nil
+		}
+	}
+	s.reviews = append(s.reviews, ReviewEntry{
+		ID:        uint64(len(s.reviews) + 1),
+		BalanceID: balanceID,
+		Address:   address,
+		Balance:   balance,
+		Reasons:   reasons,
+		State:     ReviewPending,
+		DateAdded: time.Now(),
+	})
+	return true, nil
+}
+
+func (s *MemoryStore) GetReview(ctx context.Context, reviewID uint64) (ReviewEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reviewID == 0 || reviewID > uint64(len(s.reviews)) {
+		return ReviewEntry{}, ErrNotFound
+	}
+	return s.reviews[reviewID-1], nil
+}
+
+func (s *MemoryStore) GetReviews(ctx context.Context, state string) ([]ReviewEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]ReviewEntry, 0)
+	for _, review := range s.reviews {
+		if review.State == state {
+			result = append(result, review)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetLatestReviews(ctx context.Context) ([]ReviewEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := make(map[uint64]ReviewEntry)
+	for _, review := range s.reviews {
+		latest[review.BalanceID] = review
+	}
+	result := make([]ReviewEntry, 0, len(latest))
+	for _, review := range latest {
+		result = append(result, review)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BalanceID < result[j].BalanceID })
+	return result, nil
+}
+
+func (s *MemoryStore) ResolveReview(ctx context.Context, reviewID uint64, state string, resolvedBy string, note string) (bool, error) {
+	if state != ReviewApproved && state != ReviewRejected {
+		return false, errors.New("sql: a review can only be resolved as approved or rejected")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reviewID == 0 || reviewID > uint64(len(s.reviews)) || s.reviews[reviewID-1].State != ReviewPending {
+		return false, nil
+	}
+	review := &s.reviews[reviewID-1]
+	review.State = state
+	review.ResolvedBy = resolvedBy
+	review.Note = note
+	review.DateResolved = time.Now()
+	return true, nil
+}
+
+// approval returns the approval for the ID, the caller must hold s.mu
+func (s *MemoryStore) approval(approvalID uint64) *PayoutApproval {
+	if approvalID == 0 || approvalID > uint64(len(s.approvals)) {
+		return nil
+	}
+	return &s.approvals[approvalID-1]
+}
+
+func (s *MemoryStore) CreateApproval(ctx context.Context, balanceID uint64, address string, amount uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, approval := range s.approvals {
+		if approval.BalanceID == balanceID && (approval.State == ApprovalPending || approval.State == ApprovalApproved) {
+			return false, nil
+		}
+	}
+	s.approvals = append(s.approvals, PayoutApproval{
+		ID:        uint64(len(s.approvals) + 1),
+		BalanceID: balanceID,
+		Address:   address,
+		Amount:    amount,
+		State:     ApprovalPending,
+		DateAdded: time.Now(),
+	})
+	return true, nil
+}
+
+func (s *MemoryStore) GetApproval(ctx context.Context, approvalID uint64) (PayoutApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval := s.approval(approvalID)
+	if approval == nil {
+		return PayoutApproval{}, ErrNotFound
+	}
+	return *approval, nil
+}
+
+func (s *MemoryStore) GetApprovals(ctx context.Context, state string) ([]PayoutApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]PayoutApproval, 0)
+	for _, approval := range s.approvals {
+		if approval.State == state {
+			result = append(result, approval)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetOpenApprovals(ctx context.Context) ([]PayoutApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]PayoutApproval, 0)
+	for _, approval := range s.approvals {
+		if approval.State == ApprovalPending || approval.State == ApprovalApproved {
+			result = append(result, approval)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) ResolveApproval(ctx context.Context, approvalID uint64, state string, resolvedBy string, note string) (bool, error) {
+	if state != ApprovalApproved && state != ApprovalRejected {
+		return false, errors.New("sql: an approval can only be resolved as approved or rejected")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval := s.approval(approvalID)
+	if approval == nil || approval.State != ApprovalPending {
+		return false, nil
+	}
+	approval.State = state
+	approval.ResolvedBy = resolvedBy
+	approval.Note = note
+	approval.DateResolved = time.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) ExpireApproval(ctx context.Context, approvalID uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval := s.approval(approvalID)
+	if approval == nil || approval.State != ApprovalApproved {
+		return false, nil
+	}
+	approval.State = ApprovalExpired
+	return true, nil
+}
+
+func (s *MemoryStore) ConsumeApproval(ctx context.Context, tx Tx, approvalID uint64, batchID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memTx, err := s.memTx(tx)
+	if err != nil {
+		return err
+	}
+	approval := s.approval(approvalID)
+	if approval == nil || approval.State != ApprovalApproved {
+		return ErrApprovalNotApproved
+	}
+	previous := *approval
+	approval.State = ApprovalPaid
+	approval.BatchID = batchID
+	// By index, the slice can grow before a rollback
+	memTx.undo = append(memTx.undo, func() { s.approvals[approvalID-1] = previous })
+	return nil
+}
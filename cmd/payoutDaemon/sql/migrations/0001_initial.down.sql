@@ -0,0 +1,4 @@
+drop table transaction_details;
+drop table transactions;
+drop table payment_batch;
+drop table balances;
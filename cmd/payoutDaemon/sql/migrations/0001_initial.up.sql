@@ -51,7 +51,7 @@ create index transactions_batch_id_index
 create index transactions_balance_id_index
     on transactions (balance_id);
 
-create table public.transaction_details
+create table transaction_details
 (
     id              numeric                  not null
         constraint transaction_details_pk
@@ -70,8 +70,5 @@ create table public.transaction_details
     repaid          boolean default false    not null
 );
 
-alter table public.transaction_details
-    owner to postgres;
-
 create index transaction_details_dest_address_index
-    on public.transaction_details (dest_address);
+    on transaction_details (dest_address);
@@ -0,0 +1 @@
+drop table payment_batch_recipients;
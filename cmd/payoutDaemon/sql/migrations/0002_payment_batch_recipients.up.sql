@@ -0,0 +1,26 @@
+create table payment_batch_recipients
+(
+    id           bigserial
+        constraint payment_batch_recipients_pk
+            primary key,
+    batch_id     bigint                                    not null
+        constraint payment_batch_recipients_payment_batch_id_fk
+            references payment_batch,
+    balance_id   bigint                                    not null
+        constraint payment_batch_recipients_balances_id_fk
+            references balances,
+    address      text                                      not null,
+    amount       bigint                                    not null,
+    send_amount  bigint                                    not null,
+    fee_per_gram bigint                                    not null,
+    state        text                     default 'queued' not null,
+    tx_id        numeric,
+    error        text,
+    date_added   timestamp with time zone default now()    not null,
+    date_updated timestamp with time zone default now()    not null
+);
+
+create unique index payment_batch_recipients_batch_id_balance_id_uindex
+    on payment_batch_recipients (batch_id, balance_id);
+create index payment_batch_recipients_state_index
+    on payment_batch_recipients (state);
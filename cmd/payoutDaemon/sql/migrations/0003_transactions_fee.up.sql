@@ -0,0 +1,2 @@
+alter table transactions
+    add fee bigint default 0 not null;
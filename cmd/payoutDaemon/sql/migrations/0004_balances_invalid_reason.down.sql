@@ -0,0 +1,2 @@
+alter table balances
+    drop column invalid_reason;
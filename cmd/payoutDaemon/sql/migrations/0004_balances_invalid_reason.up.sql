@@ -0,0 +1,2 @@
+alter table balances
+    add invalid_reason text;
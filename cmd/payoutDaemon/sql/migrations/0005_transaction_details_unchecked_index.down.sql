@@ -0,0 +1 @@
+drop index transaction_details_unchecked_index;
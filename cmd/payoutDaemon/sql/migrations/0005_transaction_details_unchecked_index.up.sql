@@ -0,0 +1,3 @@
+create index transaction_details_unchecked_index
+    on transaction_details (timestamp)
+    where rechecked = false;
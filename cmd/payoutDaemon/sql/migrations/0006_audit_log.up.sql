@@ -0,0 +1,16 @@
+create table audit_log
+(
+    id         bigserial
+        constraint audit_log_pk
+            primary key,
+    run_id     text,
+    actor      text                                   not null,
+    action     text                                   not null,
+    subject    text                                   not null,
+    detail     text,
+    date_added timestamp with time zone default now() not null
+);
+create index audit_log_run_id_index
+    on audit_log (run_id);
+create index audit_log_date_added_index
+    on audit_log (date_added);
@@ -0,0 +1,21 @@
+create table balance_ledger
+(
+    id            bigserial
+        constraint balance_ledger_pk
+            primary key,
+    balance_id    bigint                                 not null
+        constraint balance_ledger_balances_id_fk
+            references balances,
+    entry_type    text                                   not null,
+    amount        bigint                                 not null,
+    balance_after bigint                                 not null,
+    reference     text,
+    date_added    timestamp with time zone default now() not null
+);
+create index balance_ledger_balance_id_index
+    on balance_ledger (balance_id);
+
+-- Existing balances have no history, open the ledger at whatever they hold now
+insert into balance_ledger (balance_id, entry_type, amount, balance_after, reference)
+select id, 'opening', balance, balance, 'opening balance'
+from balances;
@@ -0,0 +1,23 @@
+create table balance_review_queue
+(
+    id            bigserial
+        constraint balance_review_queue_pk
+            primary key,
+    balance_id    bigint                                    not null
+        constraint balance_review_queue_balances_id_fk
+            references balances,
+    address       text                                      not null,
+    balance       bigint                                    not null,
+    reasons       text                                      not null,
+    state         text                     default 'pending' not null,
+    resolved_by   text,
+    note          text,
+    date_added    timestamp with time zone default now()    not null,
+    date_resolved timestamp with time zone
+);
+create index balance_review_queue_balance_id_index
+    on balance_review_queue (balance_id);
+-- A balance is only ever waiting on a single review
+create unique index balance_review_queue_pending_uindex
+    on balance_review_queue (balance_id)
+    where state = 'pending';
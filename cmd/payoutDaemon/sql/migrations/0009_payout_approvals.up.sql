@@ -0,0 +1,25 @@
+create table payout_approvals
+(
+    id            bigserial
+        constraint payout_approvals_pk
+            primary key,
+    balance_id    bigint                                    not null
+        constraint payout_approvals_balances_id_fk
+            references balances,
+    address       text                                      not null,
+    amount        bigint                                    not null,
+    state         text                     default 'pending' not null,
+    resolved_by   text,
+    note          text,
+    batch_id      bigint
+        constraint payout_approvals_payment_batch_id_fk
+            references payment_batch,
+    date_added    timestamp with time zone default now()    not null,
+    date_resolved timestamp with time zone
+);
+create index payout_approvals_balance_id_index
+    on payout_approvals (balance_id);
+-- A balance only ever has a single approval in flight
+create unique index payout_approvals_open_uindex
+    on payout_approvals (balance_id)
+    where state in ('pending', 'approved');
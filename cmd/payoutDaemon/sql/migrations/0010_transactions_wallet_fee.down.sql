@@ -0,0 +1,6 @@
+update transactions t
+set fee = b.fee
+from transactions_fee_0010 b
+where b.id = t.id;
+
+drop table transactions_fee_0010;
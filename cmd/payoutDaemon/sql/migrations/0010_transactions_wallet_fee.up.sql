@@ -0,0 +1,14 @@
+-- transactions.fee is the fee the wallet charged, fill it in from the stored wallet data for every payout recorded
+-- before that, whether it had the estimate or, from before 0003, nothing at all.  The values replaced are kept in
+-- transactions_fee_0010 for the down migration.
+create table transactions_fee_0010 as
+select t.id, t.fee
+from transactions t
+         join transaction_details d on d.id = t.id
+where t.fee <> d.fee;
+
+update transactions t
+set fee = d.fee
+from transaction_details d
+where d.id = t.id
+  and t.fee <> d.fee;
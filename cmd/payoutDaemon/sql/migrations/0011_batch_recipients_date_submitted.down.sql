@@ -0,0 +1,3 @@
+drop index payment_batch_recipients_date_submitted_index;
+alter table payment_batch_recipients
+    drop column date_submitted;
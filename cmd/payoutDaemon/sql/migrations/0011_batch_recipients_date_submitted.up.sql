@@ -0,0 +1,11 @@
+-- When the recipient was handed to the wallet, date_updated moves again once the send is resolved.  Recipients from
+-- before this only have the time they were queued, which is close enough for them.
+alter table payment_batch_recipients
+    add date_submitted timestamp with time zone;
+
+update payment_batch_recipients
+set date_submitted = date_added
+where state <> 'queued';
+
+create index payment_batch_recipients_date_submitted_index
+    on payment_batch_recipients (date_submitted);
@@ -0,0 +1,261 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/* migrations holds the schema payoutDaemon and the tools expect, as a numbered set of up/down SQL files embedded in the
+	binary.
+
+Files are named <version>_<name>.up.sql and <version>_<name>.down.sql, versions must be unique and are applied in
+	order.  Applied versions are tracked in `schema_migrations`, and each migration is run in its own PSQL txn along with
+	its `schema_migrations` row, so a failed migration leaves nothing behind.  Never edit a migration once it has been
+	released, add a new one instead.
+
+Databases created before migrations existed already have the 0001 schema, run `migrate baseline 1` against them once
+	to record it as applied without running it.
+*/
+
+//go:embed *.sql
+var files embed.FS
+
+var fileNameRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// lockID is the advisory lock held while migrating so two instances can't migrate at once
+const lockID = 7210345601
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load returns every embedded migration, in version order
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %v is not named <version>_<name>.(up|down).sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %v is used by both %v and %v", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%v is missing its up or down file", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock, with schema_migrations in place
+func withLock(milieu *core.Milieu, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := milieu.GetRawPGXPool().Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(context.Background(), "select pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "select pg_advisory_unlock($1)", lockID)
+	if _, err = conn.Exec(context.Background(), `create table if not exists schema_migrations
+(
+    version    integer                                not null
+        constraint schema_migrations_pk
+            primary key,
+    name       text                                   not null,
+    applied_at timestamp with time zone default now() not null
+)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedVersions(conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(context.Background(), "select version, applied_at from schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+	return result, rows.Err()
+}
+
+func apply(conn *pgxpool.Conn, migration Migration, up bool) error {
+	txn, err := conn.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(context.Background())
+	body := migration.Down
+	if up {
+		body = migration.Up
+	}
+	// No arguments means pgx sends this over the simple protocol, so a file can hold as many statements as it needs.
+	if _, err = txn.Exec(context.Background(), body); err != nil {
+		return fmt.Errorf("migration %04d_%v: %w", migration.Version, migration.Name, err)
+	}
+	if up {
+		_, err = txn.Exec(context.Background(), "insert into schema_migrations (version, name) values ($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = txn.Exec(context.Background(), "delete from schema_migrations where version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return txn.Commit(context.Background())
+}
+
+// Up applies every migration that hasn't been applied yet, returning the ones it ran
+func Up(milieu *core.Milieu) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	ran := make([]Migration, 0)
+	err = withLock(milieu, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err = apply(conn, migration, true); err != nil {
+				return err
+			}
+			ran = append(ran, migration)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down reverts the most recently applied migrations, up to steps of them, returning the ones it reverted
+func Down(milieu *core.Milieu, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, errors.New("down needs at least one step")
+	}
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	ran := make([]Migration, 0)
+	err = withLock(milieu, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(ran) < steps; i-- {
+			if _, ok := applied[migrations[i].Version]; !ok {
+				continue
+			}
+			if err = apply(conn, migrations[i], false); err != nil {
+				return err
+			}
+			ran = append(ran, migrations[i])
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Baseline records every migration up to and including version as applied without running it, for databases that
+// were set up by hand before migrations existed
+func Baseline(milieu *core.Milieu, version int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	return withLock(milieu, func(conn *pgxpool.Conn) error {
+		for _, migration := range migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, err := conn.Exec(context.Background(), "insert into schema_migrations (version, name) values ($1, $2) on conflict do nothing", migration.Version, migration.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetStatus returns every migration along with whether it has been applied, plus any applied version that is not
+// embedded in this binary, which usually means the database is newer than the code
+func GetStatus(milieu *core.Milieu) ([]Status, []int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make([]Status, 0, len(migrations))
+	unknown := make([]int, 0)
+	err = withLock(milieu, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		known := make(map[int]bool)
+		for _, migration := range migrations {
+			appliedAt, ok := applied[migration.Version]
+			result = append(result, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+			known[migration.Version] = true
+		}
+		for version := range applied {
+			if !known[version] {
+				unknown = append(unknown, version)
+			}
+		}
+		sort.Ints(unknown)
+		return nil
+	})
+	return result, unknown, err
+}
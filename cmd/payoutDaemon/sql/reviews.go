@@ -0,0 +1,108 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// Manage all balance review queue SQL requests, no logic, just query and structs
+// Error management is lifted up and out despite access to sentry here.
+
+// A balance that looks wrong before a payout is put in the review queue instead of being paid, it stays out of the
+// payout rotation while its review is pending.  An approved review lets the balance through until it next increases.
+
+const (
+	// ReviewPending is waiting on a human, the balance is not paid
+	ReviewPending = "pending"
+	// ReviewApproved was checked and can be paid
+	ReviewApproved = "approved"
+	// ReviewRejected was checked and the balance has been marked invalid
+	ReviewRejected = "rejected"
+)
+
+type ReviewEntry struct {
+	ID           uint64
+	BalanceID    uint64
+	Address      string
+	Balance      uint64
+	Reasons      string
+	State        string
+	ResolvedBy   string
+	Note         string
+	DateAdded    time.Time
+	DateResolved time.Time
+}
+
+const reviewColumns = "id, balance_id, address, balance, reasons, state, coalesce(resolved_by, ''), coalesce(note, ''), date_added, coalesce(date_resolved, 'epoch')"
+
+func scanReviews(rows pgx.Rows) ([]ReviewEntry, error) {
+	defer rows.Close()
+	result := make([]ReviewEntry, 0)
+	for rows.Next() {
+		var row ReviewEntry
+		if err := rows.Scan(&row.ID, &row.BalanceID, &row.Address, &row.Balance, &row.Reasons, &row.State, &row.ResolvedBy, &row.Note,
+			&row.DateAdded, &row.DateResolved); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// CreateReview queues the balance for review, it returns false if the balance was already waiting on one
+func CreateReview(ctx context.Context, milieu *core.Milieu, balanceID uint64, address string, balance uint64, reasons string) (bool, error) {
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "insert into balance_review_queue (balance_id, address, balance, reasons) values ($1, $2, $3, $4) on conflict (balance_id) where state = 'pending' do nothing", balanceID, address, balance, reasons)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetReview returns a single review, ErrNotFound if there isn't one
+func GetReview(ctx context.Context, milieu *core.Milieu, reviewID uint64) (ReviewEntry, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+reviewColumns+" from balance_review_queue where id = $1", reviewID)
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	result, err := scanReviews(rows)
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	if len(result) == 0 {
+		return ReviewEntry{}, ErrNotFound
+	}
+	return result[0], nil
+}
+
+// GetReviews returns every review in the given state, oldest first
+func GetReviews(ctx context.Context, milieu *core.Milieu, state string) ([]ReviewEntry, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+reviewColumns+" from balance_review_queue where state = $1 order by id asc", state)
+	if err != nil {
+		return nil, err
+	}
+	return scanReviews(rows)
+}
+
+// GetLatestReviews returns the most recent review of every balance that has ever been reviewed
+func GetLatestReviews(ctx context.Context, milieu *core.Milieu) ([]ReviewEntry, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select distinct on (balance_id) "+reviewColumns+" from balance_review_queue order by balance_id, id desc")
+	if err != nil {
+		return nil, err
+	}
+	return scanReviews(rows)
+}
+
+// ResolveReview moves a pending review to approved or rejected, it returns false if the review wasn't pending
+func ResolveReview(ctx context.Context, milieu *core.Milieu, reviewID uint64, state string, resolvedBy string, note string) (bool, error) {
+	if state != ReviewApproved && state != ReviewRejected {
+		return false, errors.New("sql: a review can only be resolved as approved or rejected")
+	}
+	tag, err := milieu.GetRawPGXPool().Exec(ctx, "update balance_review_queue set state = $1, resolved_by = $2, note = nullif($3, ''), date_resolved = now() where id = $4 and state = $5", state, resolvedBy, note, reviewID, ReviewPending)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,558 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// Store interfaces over the query functions in this package, so anything above it can be handed PostgresStore in
+// production or MemoryStore in tests and tools.  Anything that has to be atomic takes a Tx from Store.Begin, and every
+// store taking part in the same Tx must come from the same Store.  Every call takes the caller's context, a query gives
+// up with the context's error once it is done.
+
+var ErrNotFound = errors.New("sql: no rows found")
+var ErrForeignTx = errors.New("sql: transaction was not started by this store")
+
+// Tx is a unit of work, nothing done through it is kept until Commit.  Rollback after Commit is a no-op so it can
+// always be deferred.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+type BalanceStore interface {
+	GetAllBalances(ctx context.Context, balancesSelectOrder int) ([]BalanceSqlRow, error)
+	GetBalanceIDByAddress(ctx context.Context, address string) (uint64, error)
+	GetOrCreateBalance(ctx context.Context, tx Tx, address string) (uint64, error)
+	MarkBalanceInvalid(ctx context.Context, balanceID uint64, reason string) error
+	MarkBalanceValid(ctx context.Context, balanceID uint64) error
+	SetPayoutMinimum(ctx context.Context, balanceID uint64, payoutMinimum uint64) error
+	DecreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error
+	IncreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error
+}
+
+type LedgerStore interface {
+	GetLedgerEntries(ctx context.Context, balanceID uint64) ([]LedgerEntry, error)
+	GetLedgerDrift(ctx context.Context) ([]LedgerDrift, error)
+	CreateLedgerAdjustment(ctx context.Context, balanceID uint64, amount int64, reference string) error
+	GetCreditedSince(ctx context.Context, since time.Time) ([]BalanceCredit, error)
+}
+
+type BatchStore interface {
+	CreateNewBatch(ctx context.Context, txCount int, amount uint64) (int, error)
+	GetBatch(ctx context.Context, batchID int) (BatchSqlRow, error)
+	GetLatestBatch(ctx context.Context) (BatchSqlRow, error)
+	UpdateBatchAmounts(ctx context.Context, batchID int, successAmount uint64, failedAmount uint64) error
+	RefreshBatchAmounts(ctx context.Context, batchID int) error
+	CreateBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, address string, amount uint64, sendAmount uint64, feePerGram uint64) error
+	SetBatchRecipientsSubmitted(ctx context.Context, batchID int, balanceIDs []uint64) (int64, error)
+	ResolveBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, from string, state string, txID uint64, errorString string) error
+	GetBatchRecipients(ctx context.Context, batchID int, state string) ([]BatchRecipientSqlRow, error)
+	GetAllBatchRecipients(ctx context.Context, batchID int) ([]BatchRecipientSqlRow, error)
+	GetAllBatchRecipientsByState(ctx context.Context, state string) ([]BatchRecipientSqlRow, error)
+	GetSpendSince(ctx context.Context, since time.Time) ([]BalanceSpend, error)
+}
+
+type TransactionStore interface {
+	CreateNewTransaction(ctx context.Context, tx Tx, txID uint64, success bool, errorString string, balanceID uint64, batchID int, amount uint64, fee uint64) error
+	FailTransaction(ctx context.Context, tx Tx, txID uint64, errorString string) (bool, error)
+	SetTransactionFee(ctx context.Context, txID uint64, fee uint64) error
+	GetTransaction(ctx context.Context, txID uint64) (TransactionSqlRow, error)
+	GetAllTransactions(ctx context.Context) ([]TransactionSqlRow, error)
+	GetSuccessfulTransactionIDs(ctx context.Context) ([]uint64, error)
+	GetRecordedTransactionIDs(ctx context.Context, txIDs []uint64) (map[uint64]bool, error)
+	GetPayoutHistory(ctx context.Context) ([]PayoutHistory, error)
+}
+
+type TransactionDetailStore interface {
+	CreateTransactionDetail(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error
+	CreateTransactionDetails(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error)
+	UpdateMinedAtHeights(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error)
+	GetTransactionIDsWithoutDetail(ctx context.Context) ([]uint64, error)
+	UpdateMinedAtHeight(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error
+	TransactionDetailExists(ctx context.Context, txID uint64) (bool, error)
+	GetUnminedTransactionDetailIDs(ctx context.Context) ([]uint64, error)
+	GetUncheckedTransactionDetails(ctx context.Context) ([]TransactionDetail, error)
+	GetAllTransactionDetails(ctx context.Context) ([]TransactionDetail, error)
+	UpdateTransactionDetailStatus(ctx context.Context, txID uint64, status uint64, isCancelled bool, minedAtHeight uint64, rechecked bool) error
+	SetTransactionDetailRepaid(ctx context.Context, tx Tx, txID uint64) error
+}
+
+type AuditStore interface {
+	CreateAuditEntry(ctx context.Context, entry AuditEntry) error
+	GetAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error)
+}
+
+type ReviewStore interface {
+	CreateReview(ctx context.Context, balanceID uint64, address string, balance uint64, reasons string) (bool, error)
+	GetReview(ctx context.Context, reviewID uint64) (ReviewEntry, error)
+	GetReviews(ctx context.Context, state string) ([]ReviewEntry, error)
+	GetLatestReviews(ctx context.Context) ([]ReviewEntry, error)
+	ResolveReview(ctx context.Context, reviewID uint64, state string, resolvedBy string, note string) (bool, error)
+}
+
+type ApprovalStore interface {
+	CreateApproval(ctx context.Context, balanceID uint64, address string, amount uint64) (bool, error)
+	GetApproval(ctx context.Context, approvalID uint64) (PayoutApproval, error)
+	GetApprovals(ctx context.Context, state string) ([]PayoutApproval, error)
+	GetOpenApprovals(ctx context.Context) ([]PayoutApproval, error)
+	ResolveApproval(ctx context.Context, approvalID uint64, state string, resolvedBy string, note string) (bool, error)
+	ExpireApproval(ctx context.Context, approvalID uint64) (bool, error)
+	ConsumeApproval(ctx context.Context, tx Tx, approvalID uint64, batchID int) error
+}
+
+// Store is every store backed by the same database, along with the ability to start a Tx across them
+type Store interface {
+	BalanceStore
+	LedgerStore
+	BatchStore
+	TransactionStore
+	TransactionDetailStore
+	AuditStore
+	ReviewStore
+	ApprovalStore
+	// Begin starts a Tx, ctx only covers starting it.  Commit and Rollback always run to completion so a Tx is never
+	// left half done by a cancelled caller.
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// DefaultQueryTimeout is how long a single PostgresStore call gets unless QueryTimeout is changed
+const DefaultQueryTimeout = 30 * time.Second
+
+// BulkQueryTimeout is for the one-off CLIs that load whole tables, backfills, reconcile and ledgerVerify
+const BulkQueryTimeout = 10 * time.Minute
+
+// PostgresStore is the production Store, each method is a thin wrapper around the query function of the same name.
+// Every call is limited to QueryTimeout on top of whatever deadline the caller's context has, so a hung connection
+// surfaces as context.DeadlineExceeded rather than a stall, 0 leaves it to the caller.
+type PostgresStore struct {
+	milieu       *core.Milieu
+	QueryTimeout time.Duration
+}
+
+func NewPostgresStore(milieu *core.Milieu) *PostgresStore {
+	return &PostgresStore{milieu: milieu, QueryTimeout: DefaultQueryTimeout}
+}
+
+func (p *PostgresStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.QueryTimeout)
+}
+
+type postgresTx struct {
+	tx pgx.Tx
+}
+
+func (p *postgresTx) Commit() error {
+	return p.tx.Commit(context.Background())
+}
+
+func (p *postgresTx) Rollback() error {
+	err := p.tx.Rollback(context.Background())
+	if errors.Is(err, pgx.ErrTxClosed) {
+		return nil
+	}
+	return err
+}
+
+// Begin starts a Tx on its own connection from the pool, so it isn't tied to the Milieu txn state
+func (p *PostgresStore) Begin(ctx context.Context) (Tx, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.milieu.GetRawPGXPool().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresTx{tx: tx}, nil
+}
+
+func pgxTx(tx Tx) (pgx.Tx, error) {
+	if pgTx, ok := tx.(*postgresTx); ok {
+		return pgTx.tx, nil
+	}
+	return nil, ErrForeignTx
+}
+
+func (p *PostgresStore) GetAllBalances(ctx context.Context, balancesSelectOrder int) ([]BalanceSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAllBalances(ctx, p.milieu, balancesSelectOrder)
+}
+
+func (p *PostgresStore) GetBalanceIDByAddress(ctx context.Context, address string) (uint64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetBalanceIDByAddress(ctx, p.milieu, address)
+}
+
+func (p *PostgresStore) GetOrCreateBalance(ctx context.Context, tx Tx, address string) (uint64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	return GetOrCreateBalance(ctx, txn, address)
+}
+
+func (p *PostgresStore) MarkBalanceInvalid(ctx context.Context, balanceID uint64, reason string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return MarkBalanceInvalid(ctx, p.milieu, balanceID, reason)
+}
+
+func (p *PostgresStore) MarkBalanceValid(ctx context.Context, balanceID uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return MarkBalanceValid(ctx, p.milieu, balanceID)
+}
+
+func (p *PostgresStore) SetPayoutMinimum(ctx context.Context, balanceID uint64, payoutMinimum uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return SetPayoutMinimum(ctx, p.milieu, balanceID, payoutMinimum)
+}
+
+func (p *PostgresStore) DecreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return DecreaseBalance(ctx, txn, balanceID, amount, entryType, reference)
+}
+
+func (p *PostgresStore) IncreaseBalance(ctx context.Context, tx Tx, balanceID uint64, amount uint64, entryType string, reference string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return IncreaseBalance(ctx, txn, balanceID, amount, entryType, reference)
+}
+
+func (p *PostgresStore) GetLedgerEntries(ctx context.Context, balanceID uint64) ([]LedgerEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetLedgerEntries(ctx, p.milieu, balanceID)
+}
+
+func (p *PostgresStore) GetLedgerDrift(ctx context.Context) ([]LedgerDrift, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetLedgerDrift(ctx, p.milieu)
+}
+
+func (p *PostgresStore) CreateLedgerAdjustment(ctx context.Context, balanceID uint64, amount int64, reference string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateLedgerAdjustment(ctx, p.milieu, balanceID, amount, reference)
+}
+
+func (p *PostgresStore) CreateNewBatch(ctx context.Context, txCount int, amount uint64) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateNewBatch(ctx, p.milieu, txCount, amount)
+}
+
+func (p *PostgresStore) GetBatch(ctx context.Context, batchID int) (BatchSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetBatch(ctx, p.milieu, batchID)
+}
+
+func (p *PostgresStore) GetLatestBatch(ctx context.Context) (BatchSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetLatestBatch(ctx, p.milieu)
+}
+
+func (p *PostgresStore) UpdateBatchAmounts(ctx context.Context, batchID int, successAmount uint64, failedAmount uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return UpdateBatchAmounts(ctx, p.milieu, batchID, successAmount, failedAmount)
+}
+
+func (p *PostgresStore) RefreshBatchAmounts(ctx context.Context, batchID int) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return RefreshBatchAmounts(ctx, p.milieu, batchID)
+}
+
+func (p *PostgresStore) CreateBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, address string, amount uint64, sendAmount uint64, feePerGram uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return CreateBatchRecipient(ctx, txn, batchID, balanceID, address, amount, sendAmount, feePerGram)
+}
+
+func (p *PostgresStore) SetBatchRecipientsSubmitted(ctx context.Context, batchID int, balanceIDs []uint64) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return SetBatchRecipientsSubmitted(ctx, p.milieu, batchID, balanceIDs)
+}
+
+func (p *PostgresStore) ResolveBatchRecipient(ctx context.Context, tx Tx, batchID int, balanceID uint64, from string, state string, txID uint64, errorString string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return ResolveBatchRecipient(ctx, txn, batchID, balanceID, from, state, txID, errorString)
+}
+
+func (p *PostgresStore) GetBatchRecipients(ctx context.Context, batchID int, state string) ([]BatchRecipientSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetBatchRecipients(ctx, p.milieu, batchID, state)
+}
+
+func (p *PostgresStore) GetAllBatchRecipients(ctx context.Context, batchID int) ([]BatchRecipientSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAllBatchRecipients(ctx, p.milieu, batchID)
+}
+
+func (p *PostgresStore) GetAllBatchRecipientsByState(ctx context.Context, state string) ([]BatchRecipientSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAllBatchRecipientsByState(ctx, p.milieu, state)
+}
+
+func (p *PostgresStore) GetSpendSince(ctx context.Context, since time.Time) ([]BalanceSpend, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetSpendSince(ctx, p.milieu, since)
+}
+
+func (p *PostgresStore) CreateNewTransaction(ctx context.Context, tx Tx, txID uint64, success bool, errorString string, balanceID uint64, batchID int, amount uint64, fee uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return CreateNewTransaction(ctx, txn, txID, success, errorString, balanceID, batchID, amount, fee)
+}
+
+func (p *PostgresStore) FailTransaction(ctx context.Context, tx Tx, txID uint64, errorString string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return false, err
+	}
+	return FailTransaction(ctx, txn, txID, errorString)
+}
+
+func (p *PostgresStore) GetTransaction(ctx context.Context, txID uint64) (TransactionSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetTransaction(ctx, p.milieu, txID)
+}
+
+func (p *PostgresStore) GetAllTransactions(ctx context.Context) ([]TransactionSqlRow, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAllTransactions(ctx, p.milieu)
+}
+
+func (p *PostgresStore) GetSuccessfulTransactionIDs(ctx context.Context) ([]uint64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetSuccessfulTransactionIDs(ctx, p.milieu)
+}
+
+func (p *PostgresStore) SetTransactionFee(ctx context.Context, txID uint64, fee uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return SetTransactionFee(ctx, p.milieu, txID, fee)
+}
+
+func (p *PostgresStore) GetRecordedTransactionIDs(ctx context.Context, txIDs []uint64) (map[uint64]bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetRecordedTransactionIDs(ctx, p.milieu, txIDs)
+}
+
+func (p *PostgresStore) CreateTransactionDetail(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateTransactionDetail(ctx, p.milieu, txnDetail)
+}
+
+func (p *PostgresStore) UpdateMinedAtHeight(ctx context.Context, txnDetail *tari_generated.TransactionInfo) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return UpdateMinedAtHeight(ctx, p.milieu, txnDetail)
+}
+
+func (p *PostgresStore) TransactionDetailExists(ctx context.Context, txID uint64) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return TransactionDetailExists(ctx, p.milieu, txID)
+}
+
+func (p *PostgresStore) GetUnminedTransactionDetailIDs(ctx context.Context) ([]uint64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetUnminedTransactionDetailIDs(ctx, p.milieu)
+}
+
+func (p *PostgresStore) GetUncheckedTransactionDetails(ctx context.Context) ([]TransactionDetail, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetUncheckedTransactionDetails(ctx, p.milieu)
+}
+
+func (p *PostgresStore) UpdateTransactionDetailStatus(ctx context.Context, txID uint64, status uint64, isCancelled bool, minedAtHeight uint64, rechecked bool) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return UpdateTransactionDetailStatus(ctx, p.milieu, txID, status, isCancelled, minedAtHeight, rechecked)
+}
+
+func (p *PostgresStore) SetTransactionDetailRepaid(ctx context.Context, tx Tx, txID uint64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return SetTransactionDetailRepaid(ctx, txn, txID)
+}
+
+func (p *PostgresStore) GetAllTransactionDetails(ctx context.Context) ([]TransactionDetail, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAllTransactionDetails(ctx, p.milieu)
+}
+
+func (p *PostgresStore) CreateAuditEntry(ctx context.Context, entry AuditEntry) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateAuditEntry(ctx, p.milieu, entry)
+}
+
+func (p *PostgresStore) GetAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetAuditEntries(ctx, p.milieu, limit)
+}
+
+func (p *PostgresStore) CreateTransactionDetails(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateTransactionDetails(ctx, p.milieu, txnDetails)
+}
+
+func (p *PostgresStore) UpdateMinedAtHeights(ctx context.Context, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return UpdateMinedAtHeights(ctx, p.milieu, txnDetails)
+}
+
+func (p *PostgresStore) GetTransactionIDsWithoutDetail(ctx context.Context) ([]uint64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetTransactionIDsWithoutDetail(ctx, p.milieu)
+}
+
+func (p *PostgresStore) GetCreditedSince(ctx context.Context, since time.Time) ([]BalanceCredit, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetCreditedSince(ctx, p.milieu, since)
+}
+
+func (p *PostgresStore) GetPayoutHistory(ctx context.Context) ([]PayoutHistory, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetPayoutHistory(ctx, p.milieu)
+}
+
+func (p *PostgresStore) CreateReview(ctx context.Context, balanceID uint64, address string, balance uint64, reasons string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateReview(ctx, p.milieu, balanceID, address, balance, reasons)
+}
+
+func (p *PostgresStore) GetReview(ctx context.Context, reviewID uint64) (ReviewEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetReview(ctx, p.milieu, reviewID)
+}
+
+func (p *PostgresStore) GetReviews(ctx context.Context, state string) ([]ReviewEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetReviews(ctx, p.milieu, state)
+}
+
+func (p *PostgresStore) GetLatestReviews(ctx context.Context) ([]ReviewEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetLatestReviews(ctx, p.milieu)
+}
+
+func (p *PostgresStore) ResolveReview(ctx context.Context, reviewID uint64, state string, resolvedBy string, note string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return ResolveReview(ctx, p.milieu, reviewID, state, resolvedBy, note)
+}
+
+func (p *PostgresStore) CreateApproval(ctx context.Context, balanceID uint64, address string, amount uint64) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return CreateApproval(ctx, p.milieu, balanceID, address, amount)
+}
+
+func (p *PostgresStore) GetApproval(ctx context.Context, approvalID uint64) (PayoutApproval, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetApproval(ctx, p.milieu, approvalID)
+}
+
+func (p *PostgresStore) GetApprovals(ctx context.Context, state string) ([]PayoutApproval, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetApprovals(ctx, p.milieu, state)
+}
+
+func (p *PostgresStore) GetOpenApprovals(ctx context.Context) ([]PayoutApproval, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return GetOpenApprovals(ctx, p.milieu)
+}
+
+func (p *PostgresStore) ResolveApproval(ctx context.Context, approvalID uint64, state string, resolvedBy string, note string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return ResolveApproval(ctx, p.milieu, approvalID, state, resolvedBy, note)
+}
+
+func (p *PostgresStore) ExpireApproval(ctx context.Context, approvalID uint64) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	return ExpireApproval(ctx, p.milieu, approvalID)
+}
+
+func (p *PostgresStore) ConsumeApproval(ctx context.Context, tx Tx, approvalID uint64, batchID int) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	txn, err := pgxTx(tx)
+	if err != nil {
+		return err
+	}
+	return ConsumeApproval(ctx, txn, approvalID, batchID)
+}
@@ -2,8 +2,10 @@ package sql
 
 import (
 	"context"
+	"errors"
 	core "github.com/Snipa22/core-go-lib/milieu"
 	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"github.com/jackc/pgx/v4"
 	"time"
 )
 
@@ -23,15 +25,183 @@ type TransactionDetail struct {
 	Repaid        bool
 }
 
-func CreateTransactionDetail(milieu *core.Milieu, txnDetail *tari_generated.TransactionInfo) error {
-	_, err := milieu.GetRawPGXPool().Exec(context.Background(),
+func CreateTransactionDetail(ctx context.Context, milieu *core.Milieu, txnDetail *tari_generated.TransactionInfo) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx,
 		"insert into transaction_details (id, status, amount, fee, is_cancelled, excess_sig, timestamp, raw_payment_id, mined_at_height, user_payment_id, dest_address, rechecked, repaid) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, false, false)",
 		txnDetail.TxId, txnDetail.Status.Number(), txnDetail.Amount, txnDetail.Fee, txnDetail.IsCancelled, txnDetail.ExcessSig, time.Unix(int64(txnDetail.Timestamp), 0), txnDetail.RawPaymentId,
 		txnDetail.MinedInBlockHeight, txnDetail.UserPaymentId, txnDetail.DestAddress)
 	return err
 }
 
-func UpdateMinedAtHeight(milieu *core.Milieu, txnDetail *tari_generated.TransactionInfo) error {
-	_, err := milieu.GetRawPGXPool().Exec(context.Background(), "update transaction_details set mined_at_height = $1 where id = $2", txnDetail.MinedInBlockHeight, txnDetail.TxId)
+func UpdateMinedAtHeight(ctx context.Context, milieu *core.Milieu, txnDetail *tari_generated.TransactionInfo) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update transaction_details set mined_at_height = $1 where id = $2", txnDetail.MinedInBlockHeight, txnDetail.TxId)
+	return err
+}
+
+// transactionDetailCopyRow is the row CreateTransactionDetails copies for a TransactionInfo, in the same column order as
+// the table
+func transactionDetailCopyRow(txnDetail *tari_generated.TransactionInfo) []interface{} {
+	// COPY won't turn a nil slice into an empty one the way the not null columns need
+	excessSig, destAddress := txnDetail.ExcessSig, txnDetail.DestAddress
+	if excessSig == nil {
+		excessSig = []byte{}
+	}
+	if destAddress == nil {
+		destAddress = []byte{}
+	}
+	return []interface{}{
+		txnDetail.TxId, int32(txnDetail.Status.Number()), txnDetail.Amount, txnDetail.Fee, txnDetail.IsCancelled, excessSig,
+		time.Unix(int64(txnDetail.Timestamp), 0), txnDetail.RawPaymentId, txnDetail.MinedInBlockHeight, txnDetail.UserPaymentId,
+		destAddress, false, false,
+	}
+}
+
+// CreateTransactionDetails stores the wallet data for many transactions at once, by COPYing them into a temp table and
+// inserting from there, rows that already exist are left alone.  It returns how many were inserted.
+func CreateTransactionDetails(ctx context.Context, milieu *core.Milieu, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	txn, err := milieu.GetRawPGXPool().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback(context.Background())
+	if _, err = txn.Exec(ctx, "create temp table transaction_details_import (like transaction_details including defaults) on commit drop"); err != nil {
+		return 0, err
+	}
+	rows := make([][]interface{}, 0, len(txnDetails))
+	for _, txnDetail := range txnDetails {
+		rows = append(rows, transactionDetailCopyRow(txnDetail))
+	}
+	columns := []string{"id", "status", "amount", "fee", "is_cancelled", "excess_sig", "timestamp", "raw_payment_id", "mined_at_height", "user_payment_id", "dest_address", "rechecked", "repaid"}
+	if _, err = txn.CopyFrom(ctx, pgx.Identifier{"transaction_details_import"}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+	tag, err := txn.Exec(ctx, "insert into transaction_details select * from transaction_details_import on conflict (id) do nothing")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), txn.Commit(ctx)
+}
+
+// UpdateMinedAtHeights sets the mined height for many transactions at once, the same way as CreateTransactionDetails.
+// Only rows without a mined height are touched, and only with a height the wallet actually has.  It returns how many
+// were updated.
+func UpdateMinedAtHeights(ctx context.Context, milieu *core.Milieu, txnDetails []*tari_generated.TransactionInfo) (int64, error) {
+	txn, err := milieu.GetRawPGXPool().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback(context.Background())
+	if _, err = txn.Exec(ctx, "create temp table mined_at_height_import (id numeric not null, mined_at_height numeric not null) on commit drop"); err != nil {
+		return 0, err
+	}
+	rows := make([][]interface{}, 0, len(txnDetails))
+	for _, txnDetail := range txnDetails {
+		if txnDetail.MinedInBlockHeight > 0 {
+			rows = append(rows, []interface{}{txnDetail.TxId, txnDetail.MinedInBlockHeight})
+		}
+	}
+	if _, err = txn.CopyFrom(ctx, pgx.Identifier{"mined_at_height_import"}, []string{"id", "mined_at_height"}, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+	tag, err := txn.Exec(ctx, "update transaction_details d set mined_at_height = i.mined_at_height from mined_at_height_import i where d.id = i.id and coalesce(d.mined_at_height, 0) = 0")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), txn.Commit(ctx)
+}
+
+// TransactionDetailExists checks to see if the wallet data for the TxID has been stored
+func TransactionDetailExists(ctx context.Context, milieu *core.Milieu, txID uint64) (bool, error) {
+	var id uint64
+	err := milieu.GetRawPGXPool().QueryRow(ctx, "select id from transaction_details where id = $1", txID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetTransactionIDsWithoutDetail returns the TxID of every successful transaction without its wallet data stored
+func GetTransactionIDsWithoutDetail(ctx context.Context, milieu *core.Milieu) ([]uint64, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select t.id from transactions t left join transaction_details d on d.id = t.id where t.success is true and d.id is null")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// GetUnminedTransactionDetailIDs returns the TxID of every stored transaction without a mined height
+func GetUnminedTransactionDetailIDs(ctx context.Context, milieu *core.Milieu) ([]uint64, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id from transaction_details where coalesce(mined_at_height, 0) = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// SetTransactionDetailRepaid flags the wallet data for the TxID as credited back to the balance
+func SetTransactionDetailRepaid(ctx context.Context, psqlTx pgx.Tx, txID uint64) error {
+	_, err := psqlTx.Exec(ctx, "update transaction_details set repaid = true where id = $1", txID)
+	return err
+}
+
+const transactionDetailColumns = "id, status, amount, fee, is_cancelled, excess_sig, timestamp, raw_payment_id, coalesce(mined_at_height, 0), user_payment_id, dest_address, rechecked, repaid"
+
+func scanTransactionDetails(rows pgx.Rows) ([]TransactionDetail, error) {
+	defer rows.Close()
+	result := make([]TransactionDetail, 0)
+	for rows.Next() {
+		var row TransactionDetail
+		if err := rows.Scan(&row.ID, &row.Status, &row.Amount, &row.Fee, &row.IsCancelled, &row.ExcessSig, &row.Timestamp, &row.RawPaymentID,
+			&row.MinedAtHeight, &row.UserPaymentID, &row.DestAddress, &row.Rechecked, &row.Repaid); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// GetUncheckedTransactionDetails returns every stored transaction that hasn't reached a final state yet, oldest first
+func GetUncheckedTransactionDetails(ctx context.Context, milieu *core.Milieu) ([]TransactionDetail, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+transactionDetailColumns+" from transaction_details where rechecked = false order by timestamp asc")
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactionDetails(rows)
+}
+
+// GetAllTransactionDetails returns every stored transaction, oldest first
+func GetAllTransactionDetails(ctx context.Context, milieu *core.Milieu) ([]TransactionDetail, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select "+transactionDetailColumns+" from transaction_details order by timestamp asc")
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactionDetails(rows)
+}
+
+// UpdateTransactionDetailStatus stores the latest wallet view of a transaction, rechecked marks it as final so it is no
+// longer polled
+func UpdateTransactionDetailStatus(ctx context.Context, milieu *core.Milieu, txID uint64, status uint64, isCancelled bool, minedAtHeight uint64, rechecked bool) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update transaction_details set status = $1, is_cancelled = $2, mined_at_height = $3, rechecked = $4 where id = $5", status, isCancelled, minedAtHeight, rechecked, txID)
 	return err
 }
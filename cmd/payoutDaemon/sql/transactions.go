@@ -2,10 +2,133 @@ package sql
 
 import (
 	"context"
+	"errors"
+	core "github.com/Snipa22/core-go-lib/milieu"
 	"github.com/jackc/pgx/v4"
 )
 
-func CreateNewTransaction(psqlTx pgx.Tx, txID uint64, success bool, errorString string, balanceID uint64, batchID int, amount uint64) error {
-	_, err := psqlTx.Exec(context.Background(), "insert into transactions (id, success, error, balance_id, batch_id, amount) values ($1, $2, $3, $4, $5, $6) ON CONFLICT ON CONSTRAINT transactions_pk DO UPDATE SET success = $2, error = $3, balance_id = $4, batch_id = $5, amount = $6", txID, success, errorString, balanceID, batchID, amount)
+// CreateNewTransaction records the wallet result for a payout, amount is what was debited from the balance and fee is
+// the part of it that went to the network rather than the recipient
+func CreateNewTransaction(ctx context.Context, psqlTx pgx.Tx, txID uint64, success bool, errorString string, balanceID uint64, batchID int, amount uint64, fee uint64) error {
+	_, err := psqlTx.Exec(ctx, "insert into transactions (id, success, error, balance_id, batch_id, amount, fee) values ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT ON CONSTRAINT transactions_pk DO UPDATE SET success = $2, error = $3, balance_id = $4, batch_id = $5, amount = $6, fee = $7", txID, success, errorString, balanceID, batchID, amount, fee)
 	return err
 }
+
+// GetRecordedTransactionIDs returns which of the wallet TxIDs have already been recorded against a balance
+func GetRecordedTransactionIDs(ctx context.Context, milieu *core.Milieu, txIDs []uint64) (map[uint64]bool, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id from transactions where id = any($1)", txIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[uint64]bool)
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result[id] = true
+	}
+	return result, rows.Err()
+}
+
+type TransactionSqlRow struct {
+	ID        uint64
+	Success   bool
+	Error     string
+	BalanceID uint64
+	BatchID   int
+	Amount    uint64
+	Fee       uint64
+}
+
+// GetTransaction returns the recorded transaction for the wallet TxID, ErrNotFound if there isn't one
+func GetTransaction(ctx context.Context, milieu *core.Milieu, txID uint64) (TransactionSqlRow, error) {
+	var row TransactionSqlRow
+	err := milieu.GetRawPGXPool().QueryRow(ctx, "select id, success, coalesce(error, ''), balance_id, batch_id, amount, fee from transactions where id = $1", txID).Scan(
+		&row.ID, &row.Success, &row.Error, &row.BalanceID, &row.BatchID, &row.Amount, &row.Fee,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// GetSuccessfulTransactionIDs returns the wallet TxID of every transaction the wallet accepted
+func GetSuccessfulTransactionIDs(ctx context.Context, milieu *core.Milieu) ([]uint64, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id from transactions where success is true")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// GetAllTransactions returns every recorded transaction
+func GetAllTransactions(ctx context.Context, milieu *core.Milieu) ([]TransactionSqlRow, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select id, success, coalesce(error, ''), balance_id, batch_id, amount, fee from transactions order by id asc")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]TransactionSqlRow, 0)
+	for rows.Next() {
+		var row TransactionSqlRow
+		if err = rows.Scan(&row.ID, &row.Success, &row.Error, &row.BalanceID, &row.BatchID, &row.Amount, &row.Fee); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// FailTransaction flags a previously successful transaction as failed, the caller is responsible for the balance.  It
+// returns false if the transaction wasn't successful to begin with, as the row is locked until the PSQL txn ends this
+// makes it the guard against crediting the same transaction back twice.
+func FailTransaction(ctx context.Context, psqlTx pgx.Tx, txID uint64, errorString string) (bool, error) {
+	tag, err := psqlTx.Exec(ctx, "update transactions set success = false, error = $2 where id = $1 and success = true", txID, errorString)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetTransactionFee records the fee the wallet charged for a transaction, it isn't known until the wallet reports the
+// transaction back
+func SetTransactionFee(ctx context.Context, milieu *core.Milieu, txID uint64, fee uint64) error {
+	_, err := milieu.GetRawPGXPool().Exec(ctx, "update transactions set fee = $2 where id = $1", txID, fee)
+	return err
+}
+
+// PayoutHistory is every successful payout a balance has had
+type PayoutHistory struct {
+	BalanceID uint64
+	Count     uint64
+	Largest   uint64
+}
+
+// GetPayoutHistory returns the payout history of every balance that has been paid at least once
+func GetPayoutHistory(ctx context.Context, milieu *core.Milieu) ([]PayoutHistory, error) {
+	rows, err := milieu.GetRawPGXPool().Query(ctx, "select balance_id, count(*), max(amount) from transactions where success is true group by balance_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]PayoutHistory, 0)
+	for rows.Next() {
+		var row PayoutHistory
+		if err = rows.Scan(&row.BalanceID, &row.Count, &row.Largest); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+)
+
+// Client covers every wallet call the faucet makes, GRPCClient talks to a real wallet, FakeClient keeps everything in
+// memory so the payout flow can be run without one.  Every call gives up when its context is done, for a send that means
+// we can't know whether the wallet went ahead with it, unless the error is ErrNotConnected.
+type Client interface {
+	// SendTransactions hands a batch of recipients to the wallet, one result per recipient
+	SendTransactions(ctx context.Context, transactions []*tari_generated.PaymentRecipient) (*tari_generated.TransferResponse, error)
+	// GetTransactionInfoByID looks up a single transaction, nil if the wallet returned nothing
+	GetTransactionInfoByID(ctx context.Context, transactionID uint64) (*tari_generated.TransactionInfo, error)
+	// GetTransactionsInBlock returns the completed transactions, 0 for the full wallet history
+	GetTransactionsInBlock(ctx context.Context, blockHeight uint64) ([]*tari_generated.TransactionInfo, error)
+}
+
+// GRPCClient is the production Client, the same calls as walletGRPC and like it a connection per call.  It can't
+// wrap walletGRPC, which runs every call on context.Background() with no way to pass a deadline or cancellation in and
+// gives no way to tell a connection that never came up (ErrNotConnected) from a call that failed part way.  Everything
+// in the faucet talks to the wallet through this instead.
+type GRPCClient struct {
+	walletAddress string
+}
+
+func NewGRPCClient(walletAddress string) *GRPCClient {
+	return &GRPCClient{walletAddress: walletAddress}
+}
+
+// ErrNotConnected wraps a call that failed before the request was made, the wallet never saw it
+var ErrNotConnected = errors.New("wallet not connected")
+
+// call opens a connection to the wallet for the length of fn, fn only runs once the connection is ready so any
+// failure before that is ErrNotConnected
+func (g *GRPCClient) call(ctx context.Context, fn func(client tari_generated.WalletClient) error) error {
+	conn, err := grpc.NewClient(g.walletAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	defer conn.Close()
+	if err = connect(ctx, conn); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	return fn(tari_generated.NewWalletClient(conn))
+}
+
+// connect waits for the connection to be ready, giving up on the first failed attempt rather than waiting out
+// grpc's reconnect backoff
+func connect(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("connection %v", state)
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (g *GRPCClient) SendTransactions(ctx context.Context, transactions []*tari_generated.PaymentRecipient) (resp *tari_generated.TransferResponse, err error) {
+	err = g.call(ctx, func(client tari_generated.WalletClient) error {
+		resp, err = client.Transfer(ctx, &tari_generated.TransferRequest{Recipients: transactions})
+		return err
+	})
+	return resp, err
+}
+
+func (g *GRPCClient) GetTransactionInfoByID(ctx context.Context, transactionID uint64) (txInfo *tari_generated.TransactionInfo, err error) {
+	err = g.call(ctx, func(client tari_generated.WalletClient) error {
+		txns, err := client.GetTransactionInfo(ctx, &tari_generated.GetTransactionInfoRequest{TransactionIds: []uint64{transactionID}})
+		if err == nil && len(txns.Transactions) > 0 {
+			txInfo = txns.Transactions[0]
+		}
+		return err
+	})
+	return txInfo, err
+}
+
+// GetTransactionsInBlock, like walletGRPC, passes a non-zero height on to the wallet but it doesn't seem to narrow
+// anything down, callers need to filter the results themselves
+func (g *GRPCClient) GetTransactionsInBlock(ctx context.Context, blockHeight uint64) (txns []*tari_generated.TransactionInfo, err error) {
+	err = g.call(ctx, func(client tari_generated.WalletClient) error {
+		var request *tari_generated.GetCompletedTransactionsRequest
+		if blockHeight != 0 {
+			request = &tari_generated.GetCompletedTransactionsRequest{BlockHeight: &tari_generated.BlockHeight{BlockHeight: blockHeight}}
+		}
+		stream, err := client.GetCompletedTransactions(ctx, request)
+		if err != nil {
+			return err
+		}
+		txns = make([]*tari_generated.TransactionInfo, 0)
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				txns = nil
+				return err
+			}
+			txns = append(txns, resp.Transaction)
+		}
+	})
+	return txns, err
+}
+
+// IsDropped reports whether the wallet has given up on a transaction, cancelled (including pending sends that expired)
+// or rejected by the network, the coins never reached the recipient.  NOT_FOUND is deliberately not dropped, a wallet
+// that has lost track of a transaction can't tell us whether it was sent.
+func IsDropped(txInfo *tari_generated.TransactionInfo) bool {
+	return txInfo.IsCancelled || txInfo.Status == tari_generated.TransactionStatus_TRANSACTION_STATUS_REJECTED
+}
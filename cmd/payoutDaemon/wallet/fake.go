@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"context"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/address"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"sync"
+	"time"
+)
+
+/* FakeClient is an in-memory wallet for exercising the payout flow without a live Tari wallet.
+
+Every recipient succeeds by default, and is recorded as an outbound transaction that shows up in
+	GetTransactionInfoByID and GetTransactionsInBlock just like a real send would.  Behaviour can be scripted per
+	address with ScriptAddress, and a whole SendTransactions call can be made to error with FailNextSend, optionally
+	after the transactions have been recorded to mimic a timeout after a successful send.
+*/
+
+// FakeOutcome is what the fake wallet does with a recipient
+type FakeOutcome int
+
+const (
+	// FakeSuccess sends the transaction and returns its TxID
+	FakeSuccess FakeOutcome = iota
+	// FakeFailure rejects the recipient, nothing is sent
+	FakeFailure
+	// FakeZeroTxID sends the transaction but reports a TxID of 0, as the real wallet occasionally does
+	FakeZeroTxID
+)
+
+// FakeTxWeight is the weight in grams the fake wallet charges fees on
+const FakeTxWeight = 1000
+
+type fakeSendFailure struct {
+	err       error
+	afterSend bool
+}
+
+type FakeClient struct {
+	mu           sync.Mutex
+	nextTxID     uint64
+	outcomes     map[string]FakeOutcome
+	sendFailures []fakeSendFailure
+	transactions []*tari_generated.TransactionInfo
+	sent         []*tari_generated.PaymentRecipient
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		nextTxID: 1,
+		outcomes: make(map[string]FakeOutcome),
+	}
+}
+
+// ScriptAddress sets the outcome for every future send to the address
+func (f *FakeClient) ScriptAddress(address string, outcome FakeOutcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outcomes[address] = outcome
+}
+
+// FailNextSend makes the next SendTransactions call return err, if afterSend is set the transactions are recorded in
+// the wallet first, so the caller can't tell if they went out or not
+func (f *FakeClient) FailNextSend(err error, afterSend bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sendFailures = append(f.sendFailures, fakeSendFailure{err: err, afterSend: afterSend})
+}
+
+// SetStatus moves a recorded transaction to a new status, along with the height it was mined at
+func (f *FakeClient) SetStatus(transactionID uint64, status tari_generated.TransactionStatus, minedHeight uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, txn := range f.transactions {
+		if txn.TxId == transactionID {
+			txn.Status = status
+			txn.MinedInBlockHeight = minedHeight
+			txn.IsCancelled = status == tari_generated.TransactionStatus_TRANSACTION_STATUS_REJECTED
+		}
+	}
+}
+
+// Sent returns every recipient the fake wallet has sent to, in order
+func (f *FakeClient) Sent() []*tari_generated.PaymentRecipient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*tari_generated.PaymentRecipient{}, f.sent...)
+}
+
+func (f *FakeClient) SendTransactions(ctx context.Context, transactions []*tari_generated.PaymentRecipient) (*tari_generated.TransferResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var failure *fakeSendFailure
+	if len(f.sendFailures) > 0 {
+		failure = &f.sendFailures[0]
+		f.sendFailures = f.sendFailures[1:]
+		if !failure.afterSend {
+			return nil, failure.err
+		}
+	}
+	resp := &tari_generated.TransferResponse{}
+	for _, recipient := range transactions {
+		outcome := f.outcomes[recipient.Address]
+		if outcome == FakeFailure {
+			resp.Results = append(resp.Results, &tari_generated.TransferResult{
+				Address:        recipient.Address,
+				IsSuccess:      false,
+				FailureMessage: "fake wallet scripted failure",
+			})
+			continue
+		}
+		txID := f.nextTxID
+		f.nextTxID += 1
+		// Like the real wallet DestAddress is the binary address, fall back to the string for addresses we can't parse
+		destAddress := []byte(recipient.Address)
+		if parsed, err := address.Parse(recipient.Address); err == nil {
+			destAddress = parsed.Bytes()
+		}
+		txn := &tari_generated.TransactionInfo{
+			TxId:        txID,
+			DestAddress: destAddress,
+			Status:      tari_generated.TransactionStatus_TRANSACTION_STATUS_BROADCAST,
+			Direction:   tari_generated.TransactionDirection_TRANSACTION_DIRECTION_OUTBOUND,
+			Amount:      recipient.Amount,
+			Fee:         recipient.FeePerGram * FakeTxWeight,
+			ExcessSig:   []byte{},
+			Timestamp:   uint64(time.Now().Unix()),
+		}
+		if recipient.UserPaymentId != nil {
+			txn.UserPaymentId = []byte(recipient.UserPaymentId.Utf8String)
+		}
+		f.transactions = append(f.transactions, txn)
+		f.sent = append(f.sent, recipient)
+		if outcome == FakeZeroTxID {
+			txID = 0
+		}
+		resp.Results = append(resp.Results, &tari_generated.TransferResult{
+			Address:       recipient.Address,
+			TransactionId: txID,
+			IsSuccess:     true,
+		})
+	}
+	if failure != nil {
+		return nil, failure.err
+	}
+	return resp, nil
+}
+
+func (f *FakeClient) GetTransactionInfoByID(ctx context.Context, transactionID uint64) (*tari_generated.TransactionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, txn := range f.transactions {
+		if txn.TxId == transactionID {
+			return txn, nil
+		}
+	}
+	return &tari_generated.TransactionInfo{
+		TxId:   transactionID,
+		Status: tari_generated.TransactionStatus_TRANSACTION_STATUS_NOT_FOUND,
+	}, nil
+}
+
+func (f *FakeClient) GetTransactionsInBlock(ctx context.Context, blockHeight uint64) ([]*tari_generated.TransactionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Same as the real wallet, the height doesn't narrow anything down.
+	return append([]*tari_generated.TransactionInfo{}, f.transactions...), nil
+}
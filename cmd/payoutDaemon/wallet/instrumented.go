@@ -0,0 +1,41 @@
+package wallet
+
+import (
+	"context"
+	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
+	"time"
+)
+
+// ObserveFunc is told about every call made through an InstrumentedClient, method is the Client method name
+type ObserveFunc func(method string, duration time.Duration, err error)
+
+// InstrumentedClient wraps another Client and times every call, without tying this package to a metrics library
+type InstrumentedClient struct {
+	inner   Client
+	observe ObserveFunc
+}
+
+func NewInstrumentedClient(inner Client, observe ObserveFunc) *InstrumentedClient {
+	return &InstrumentedClient{inner: inner, observe: observe}
+}
+
+func (i *InstrumentedClient) SendTransactions(ctx context.Context, transactions []*tari_generated.PaymentRecipient) (*tari_generated.TransferResponse, error) {
+	start := time.Now()
+	resp, err := i.inner.SendTransactions(ctx, transactions)
+	i.observe("SendTransactions", time.Since(start), err)
+	return resp, err
+}
+
+func (i *InstrumentedClient) GetTransactionInfoByID(ctx context.Context, transactionID uint64) (*tari_generated.TransactionInfo, error) {
+	start := time.Now()
+	txInfo, err := i.inner.GetTransactionInfoByID(ctx, transactionID)
+	i.observe("GetTransactionInfoByID", time.Since(start), err)
+	return txInfo, err
+}
+
+func (i *InstrumentedClient) GetTransactionsInBlock(ctx context.Context, blockHeight uint64) ([]*tari_generated.TransactionInfo, error) {
+	start := time.Now()
+	txns, err := i.inner.GetTransactionsInBlock(ctx, blockHeight)
+	i.observe("GetTransactionsInBlock", time.Since(start), err)
+	return txns, err
+}
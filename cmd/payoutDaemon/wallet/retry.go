@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
+)
+
+/* Wallet errors on a send come in three kinds:
+	1. ErrNotConnected, the request was never made, the wallet can't have seen it and it is safe to send again as is.
+	2. Transient, a timeout, the wallet being unavailable, or the call dropping mid request.  The wallet may well have
+		gone ahead with the send after we stopped waiting, so whoever retries has to check the wallet for it first, see
+		sendWithRetry in payoutDaemon.
+	3. Definitive, the wallet answered and turned the request down.  Sending it again would only get the same answer.
+Anything that can't be placed is treated like a definitive error, it is left for recovery rather than retried.
+*/
+
+// transientCodes are the gRPC codes worth trying again, once the wallet has been checked
+var transientCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// definitiveCodes are the wallet turning the request down, never retried
+var definitiveCodes = map[codes.Code]bool{
+	codes.InvalidArgument:    true,
+	codes.FailedPrecondition: true,
+	codes.OutOfRange:         true,
+	codes.NotFound:           true,
+	codes.AlreadyExists:      true,
+	codes.PermissionDenied:   true,
+	codes.Unauthenticated:    true,
+	codes.Unimplemented:      true,
+}
+
+// IsNotSent reports whether a wallet call that failed with err never reached the wallet, so it is safe to retry.  Our
+// own context being cancelled is never retried, whoever cancelled it wants us to stop.
+func IsNotSent(err error) bool {
+	return errors.Is(err, ErrNotConnected) && !errors.Is(err, context.Canceled)
+}
+
+// IsTransient reports whether a wallet call that failed with err is worth retrying, it may still have gone through
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || IsNotSent(err) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		return transientCodes[s.Code()]
+	}
+	return false
+}
+
+// IsDefinitive reports whether the wallet turned the call down, nothing was sent and retrying won't change that
+func IsDefinitive(err error) bool {
+	if s, ok := status.FromError(err); ok {
+		return definitiveCodes[s.Code()]
+	}
+	return false
+}
+
+// RetryPolicy is how often and how far apart a failed send is retried
+type RetryPolicy struct {
+	// Retries is how many times to try again after the first attempt, 0 never retries
+	Retries int
+	// Delay is the wait before the first retry, doubling for each one after it up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Backoff is the wait before retry number attempt, counting from 1.  Half of it is random so a set of clients that
+// failed together don't all come back at once.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Wait sleeps for the backoff before retry number attempt, returning early with the context's error if it ends first
+func (p RetryPolicy) Wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.Backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
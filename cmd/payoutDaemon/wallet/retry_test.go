@@ -0,0 +1,68 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	policy := RetryPolicy{Retries: 10, Delay: time.Second, MaxDelay: 5 * time.Second}
+	// Doubling from Delay, capped at MaxDelay, with up to half of it taken off at random
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, full := range expected {
+		attempt := i + 1
+		for run := 0; run < 50; run++ {
+			backoff := policy.Backoff(attempt)
+			if backoff < full/2 || backoff > full {
+				t.Fatalf("attempt %v: %v is outside %v-%v", attempt, backoff, full/2, full)
+			}
+		}
+	}
+	// A huge attempt count stops doubling at MaxDelay rather than overflowing
+	if backoff := policy.Backoff(1000); backoff < 0 || backoff > policy.MaxDelay {
+		t.Fatalf("got %v", backoff)
+	}
+	if backoff := (RetryPolicy{}).Backoff(1); backoff != 0 {
+		t.Fatalf("got %v, expected no wait without a delay", backoff)
+	}
+}
+
+func TestWaitStopsOnContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (RetryPolicy{Delay: time.Hour}).Wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	notConnected := fmt.Errorf("%w: connection refused", ErrNotConnected)
+	cases := []struct {
+		err        error
+		notSent    bool
+		transient  bool
+		definitive bool
+	}{
+		{err: notConnected, notSent: true},
+		{err: fmt.Errorf("%w: %w", ErrNotConnected, context.Canceled)},
+		{err: context.DeadlineExceeded, transient: true},
+		{err: context.Canceled},
+		{err: status.Error(codes.Unavailable, "connection dropped"), transient: true},
+		{err: status.Error(codes.DeadlineExceeded, "deadline"), transient: true},
+		{err: fmt.Errorf("send: %w", status.Error(codes.ResourceExhausted, "busy")), transient: true},
+		{err: status.Error(codes.InvalidArgument, "bad address"), definitive: true},
+		{err: status.Error(codes.FailedPrecondition, "not enough funds"), definitive: true},
+		{err: status.Error(codes.Unknown, "something")},
+		{err: errors.New("connection reset")},
+	}
+	for _, c := range cases {
+		if IsNotSent(c.err) != c.notSent || IsTransient(c.err) != c.transient || IsDefinitive(c.err) != c.definitive {
+			t.Fatalf("%v: got not sent %v transient %v definitive %v", c.err, IsNotSent(c.err), IsTransient(c.err), IsDefinitive(c.err))
+		}
+	}
+}
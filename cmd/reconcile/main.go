@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Snipa22/core-go-lib/helpers"
+	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/reconcile"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
+	"os"
+	"time"
+)
+
+/* reconcile compares the wallet's full transaction history with `transactions` and `transaction_details` and prints
+	every discrepancy it finds, grouped by kind, see the reconcile package for what each kind means.
+
+With --apply the discrepancies that have a safe fix (missing detail, missing fee, status drift, double-spend) are fixed, each fix
+	is written to `audit_log` under a single run ID so the whole run can be reviewed afterwards.  The rest are only
+	reported, they need a human.
+*/
+
+func main() {
+	psqlURL := helpers.GetEnv("PSQL_SERVER", "postgres://postgres@localhost/postgres?sslmode=disable")
+	sentryURI := helpers.GetEnv("SENTRY_SERVER", "")
+
+	// Build Milieu
+	milieu, err := core.NewMilieu(&psqlURL, nil, &sentryURI)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+
+	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
+	applyPtr := flag.Bool("apply", false, "Fix the discrepancies that have a safe fix, otherwise only report")
+	runIDPtr := flag.String("run-id", fmt.Sprintf("reconcile-%v", time.Now().Unix()), "ID the audit log entries of this run are recorded under")
+	actorPtr := flag.String("actor", helpers.GetEnv("USER", "reconcile"), "Who is running the reconcile, for the audit log")
+	sqlQueryTimeoutPtr := flag.Duration("sql-query-timeout", sql.BulkQueryTimeout, "Longest a single PSQL query can take before it is abandoned, 0 for no limit")
+	flag.Parse()
+	walletClient := wallet.NewGRPCClient(*walletGRPCAddressPtr)
+	store := sql.NewPostgresStore(milieu)
+	store.QueryTimeout = *sqlQueryTimeoutPtr
+
+	walletTransactions, err := walletClient.GetTransactionsInBlock(context.Background(), 0)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	transactions, err := store.GetAllTransactions(context.Background())
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	details, err := store.GetAllTransactionDetails(context.Background())
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	recipients, err := store.GetAllBatchRecipientsByState(context.Background(), sql.RecipientSucceeded)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	fmt.Printf("Reconciling %d wallet transactions against %d ledger rows and %d stored details\n", len(walletTransactions), len(transactions), len(details))
+
+	discrepancies := reconcile.Diff(walletTransactions, transactions, details, recipients)
+	counts := make(map[reconcile.Kind]int)
+	var lastKind reconcile.Kind
+	for _, d := range discrepancies {
+		if d.Kind != lastKind {
+			fmt.Printf("\n%v:\n", d.Kind)
+			lastKind = d.Kind
+		}
+		counts[d.Kind] += 1
+		fmt.Printf("  %v: %v\n", d.TxID, d.Description)
+	}
+
+	fmt.Printf("\nSummary:\n")
+	for _, kind := range reconcile.Kinds {
+		fmt.Printf("  %-20v %d\n", kind, counts[kind])
+	}
+	if len(discrepancies) == 0 || !*applyPtr {
+		if len(discrepancies) > 0 {
+			fmt.Printf("\nRun again with --apply to fix missing details and fees, status drift and double-spends\n")
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Printf("\nApplying fixes as run %v\n", *runIDPtr)
+	applied, failed, skipped := 0, 0, 0
+	for _, d := range discrepancies {
+		if !d.Fixable() {
+			skipped += 1
+			continue
+		}
+		if err = reconcile.Apply(context.Background(), store, d, *runIDPtr, *actorPtr); err != nil {
+			milieu.CaptureException(err)
+			fmt.Printf("  %v %v: FAILED: %v\n", d.Kind, d.TxID, err)
+			failed += 1
+			continue
+		}
+		fmt.Printf("  %v %v: fixed\n", d.Kind, d.TxID)
+		applied += 1
+	}
+	fmt.Printf("\n%d fixed, %d failed, %d need manual review\n", applied, failed, skipped)
+	if failed > 0 || skipped > 0 {
+		os.Exit(1)
+	}
+}
@@ -2,15 +2,13 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"github.com/Snipa22/core-go-lib/helpers"
 	core "github.com/Snipa22/core-go-lib/milieu"
 	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
 	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
-	"github.com/Snipa22/go-tari-grpc-lib/v2/walletGRPC"
-	"github.com/jackc/pgx/v4"
 )
 
 func main() {
@@ -25,63 +23,53 @@ func main() {
 	}
 
 	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
+	batchSizePtr := flag.Int("batch-size", 10000, "How many transaction details to insert per PSQL txn")
+	sqlQueryTimeoutPtr := flag.Duration("sql-query-timeout", sql.BulkQueryTimeout, "Longest a single PSQL query can take before it is abandoned, 0 for no limit")
 	flag.Parse()
-	walletGRPC.InitWalletGRPC(*walletGRPCAddressPtr)
+	if *batchSizePtr < 1 {
+		milieu.Fatal("batch-size must be at least 1")
+	}
+	walletClient := wallet.NewGRPCClient(*walletGRPCAddressPtr)
 
-	walletTransactions, err := walletGRPC.GetTransactionsInBlock(0)
+	walletTransactions, err := walletClient.GetTransactionsInBlock(context.Background(), 0)
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Fatal(err.Error())
 	}
 
-	rows, err := milieu.GetRawPGXPool().Query(context.Background(), "select id from transactions where success is true")
+	store := sql.NewPostgresStore(milieu)
+	store.QueryTimeout = *sqlQueryTimeoutPtr
+	// Let Postgres find what's missing in one anti-join rather than asking about every ID
+	idList, err := store.GetTransactionIDsWithoutDetail(context.Background())
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Fatal(err.Error())
 	}
-	defer rows.Close()
-	idList := make([]uint64, 0)
-	for rows.Next() {
-		var id uint64
-		if err = rows.Scan(&id); err != nil {
-			milieu.CaptureException(err)
-			milieu.Info(err.Error())
-			continue
-		}
-		idList = append(idList, id)
-	}
 
-	txnToBackfill := make([]uint64, 0)
+	fmt.Printf("Backfilling %d transactions, with %d from the wallet\n", len(idList), len(walletTransactions))
+
+	walletByID := make(map[uint64]*tari_generated.TransactionInfo, len(walletTransactions))
+	for _, txn := range walletTransactions {
+		walletByID[txn.TxId] = txn
+	}
+	txnToBackfill := make([]*tari_generated.TransactionInfo, 0, len(idList))
 	for _, id := range idList {
-		row := milieu.GetRawPGXPool().QueryRow(context.Background(), "select id from transaction_details where id in ($1)", id)
-		idScan := new(uint64)
-		if err := row.Scan(&idScan); err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				txnToBackfill = append(txnToBackfill, id)
-				continue
-			}
-			milieu.CaptureException(err)
-			milieu.Fatal(err.Error())
+		if txnData, ok := walletByID[id]; ok {
+			txnToBackfill = append(txnToBackfill, txnData)
 		}
 	}
 
-	fmt.Printf("Backfilling %d/%d transactions, with %d from the wallet\n", len(txnToBackfill), len(idList), len(walletTransactions))
-
-	for _, id := range txnToBackfill {
-		var txnData *tari_generated.TransactionInfo
-		for _, txn := range walletTransactions {
-			if txn.TxId == id {
-				txnData = txn
-				break
-			}
-		}
-		if txnData == nil {
-			continue
-		}
-		if err = sql.CreateTransactionDetail(milieu, txnData); err != nil {
+	var inserted int64
+	for start := 0; start < len(txnToBackfill); start += *batchSizePtr {
+		end := min(start+*batchSizePtr, len(txnToBackfill))
+		count, err := store.CreateTransactionDetails(context.Background(), txnToBackfill[start:end])
+		if err != nil {
+			// A failed chunk is rolled back as a whole, carry on with the rest and let a rerun pick it up
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
 			continue
 		}
+		inserted += count
 	}
+	fmt.Printf("Inserted %d transaction details, %d were not found in the wallet\n", inserted, len(idList)-len(txnToBackfill))
 }
@@ -7,8 +7,8 @@ import (
 	"github.com/Snipa22/core-go-lib/helpers"
 	core "github.com/Snipa22/core-go-lib/milieu"
 	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
 	"github.com/Snipa22/go-tari-grpc-lib/v2/tari_generated"
-	"github.com/Snipa22/go-tari-grpc-lib/v2/walletGRPC"
 )
 
 func main() {
@@ -23,49 +23,42 @@ func main() {
 	}
 
 	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
+	sqlQueryTimeoutPtr := flag.Duration("sql-query-timeout", sql.BulkQueryTimeout, "Longest a single PSQL query can take before it is abandoned, 0 for no limit")
 	flag.Parse()
-	walletGRPC.InitWalletGRPC(*walletGRPCAddressPtr)
+	walletClient := wallet.NewGRPCClient(*walletGRPCAddressPtr)
 
-	walletTransactions, err := walletGRPC.GetTransactionsInBlock(0)
+	walletTransactions, err := walletClient.GetTransactionsInBlock(context.Background(), 0)
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Fatal(err.Error())
 	}
 
-	rows, err := milieu.GetRawPGXPool().Query(context.Background(), "select id from transaction_details where mined_at_height = 0")
+	store := sql.NewPostgresStore(milieu)
+	store.QueryTimeout = *sqlQueryTimeoutPtr
+	idList, err := store.GetUnminedTransactionDetailIDs(context.Background())
 	if err != nil {
 		milieu.CaptureException(err)
 		milieu.Fatal(err.Error())
 	}
-	defer rows.Close()
-	idList := make([]uint64, 0)
-	for rows.Next() {
-		var id uint64
-		if err = rows.Scan(&id); err != nil {
-			milieu.CaptureException(err)
-			milieu.Info(err.Error())
-			continue
-		}
-		idList = append(idList, id)
-	}
 
 	fmt.Printf("Backfilling %d transactions with mined_at_height, with %d from the wallet\n", len(idList), len(walletTransactions))
 
+	// Only send Postgres the wallet data it can use, it does the matching in a single update
+	unmined := make(map[uint64]bool, len(idList))
 	for _, id := range idList {
-		var txnData *tari_generated.TransactionInfo
-		for _, txn := range walletTransactions {
-			if txn.TxId == id {
-				txnData = txn
-				break
-			}
-		}
-		if txnData == nil {
-			continue
-		}
-		if err = sql.UpdateMinedAtHeight(milieu, txnData); err != nil {
-			milieu.CaptureException(err)
-			milieu.Info(err.Error())
-			continue
+		unmined[id] = true
+	}
+	txnToBackfill := make([]*tari_generated.TransactionInfo, 0)
+	for _, txn := range walletTransactions {
+		if unmined[txn.TxId] && txn.MinedInBlockHeight > 0 {
+			txnToBackfill = append(txnToBackfill, txn)
 		}
 	}
+
+	updated, err := store.UpdateMinedAtHeights(context.Background(), txnToBackfill)
+	if err != nil {
+		milieu.CaptureException(err)
+		milieu.Fatal(err.Error())
+	}
+	fmt.Printf("Updated %d transactions, %d are still unmined\n", updated, int64(len(idList))-updated)
 }
@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/Snipa22/core-go-lib/helpers"
 	core "github.com/Snipa22/core-go-lib/milieu"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/repay"
 	sql2 "github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/sql"
-	"github.com/Snipa22/go-tari-grpc-lib/v2/walletGRPC"
+	"github.com/Snipa22/go-tari-faucet/cmd/payoutDaemon/wallet"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -26,7 +28,7 @@ func main() {
 	walletGRPCAddressPtr := flag.String("wallet-grpc-address", "127.0.0.1:18143", "Tari wallet GRPC address")
 	walletSqliteDBPtr := flag.String("wallet-sqlite-db", "", "Path to the source tari wallet sqlite DB")
 	flag.Parse()
-	walletGRPC.InitWalletGRPC(*walletGRPCAddressPtr)
+	walletClient := wallet.NewGRPCClient(*walletGRPCAddressPtr)
 
 	if *walletSqliteDBPtr == "" {
 		milieu.Fatal("No wallet sqlite DB provided")
@@ -39,6 +41,7 @@ func main() {
 		milieu.Fatal(err.Error())
 	}
 	defer db.Close()
+	store := sql2.NewPostgresStore(milieu)
 	rows, err := db.Query("select tx_id from completed_transactions where status = 7")
 	if err != nil {
 		milieu.CaptureException(err)
@@ -54,49 +57,35 @@ func main() {
 			milieu.Info(err.Error())
 			continue
 		}
-		psqlRow := milieu.GetRawPGXPool().QueryRow(context.Background(), "select success, amount, balance_id from transactions where id = $1", txID)
-		if psqlRow == nil {
-			milieu.CaptureException(fmt.Errorf("no transaction found with id %d", txID))
-			milieu.Info(fmt.Sprintf("No transaction found with id %d", txID))
-			continue
-		}
-		success := false
-		var amount, balanceID uint64
-		err = psqlRow.Scan(&success, &amount, &balanceID)
+		// The sqlite copy can be stale, don't credit anything the live wallet no longer reports as dropped
+		txInfo, err := walletClient.GetTransactionInfoByID(context.Background(), uint64(txID))
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
 			continue
 		}
-		if !success {
-			milieu.Info(fmt.Sprintf("transaction found with id %d, but it's been procesed, skipping", txID))
+		if txInfo != nil && !wallet.IsDropped(txInfo) {
+			milieu.Info(fmt.Sprintf("transaction %d is rejected in the sqlite DB but not in the wallet, skipping", txID))
 			continue
 		}
-		milieu.Info(fmt.Sprintf("transaction found with id %d to increase balance, doing so, and locking old txn", txID))
-		txn, err := milieu.GetTransaction()
-		if err != nil {
-			milieu.CaptureException(err)
-			milieu.Info(err.Error())
+		// Same path as the daemon's auto-repay, so a transaction it has already credited is skipped here and vice versa
+		transaction, err := repay.Repay(context.Background(), store, uint64(txID), "Transaction detected as double-spend, increased balance")
+		if errors.Is(err, sql2.ErrNotFound) {
+			milieu.CaptureException(fmt.Errorf("no transaction found with id %d", txID))
+			milieu.Info(fmt.Sprintf("No transaction found with id %d", txID))
 			continue
 		}
-		defer milieu.CleanupTxn()
-		err = sql2.IncreaseBalance(txn, balanceID, amount)
-		if err != nil {
-			milieu.CaptureException(err)
-			milieu.Info(err.Error())
-			milieu.CleanupTxn()
+		if errors.Is(err, repay.ErrNotRepayable) {
+			milieu.Info(fmt.Sprintf("transaction found with id %d, but it's been procesed, skipping", txID))
 			continue
 		}
-		_, err = txn.Exec(context.Background(), "update transactions set success = false, error = 'Transaction detected as double-spend, increased balance' where id = $1", txID)
 		if err != nil {
 			milieu.CaptureException(err)
 			milieu.Info(err.Error())
-			milieu.CleanupTxn()
 			continue
 		}
-		txn.Commit(context.Background())
+		amount, balanceID := transaction.Amount, transaction.BalanceID
 		milieu.Info(fmt.Sprintf("processed txn ID %d and incremented balance for %v by %v", txID, balanceID, amount))
-		milieu.CleanupTxn()
 	}
 	db.Close()
 }